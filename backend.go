@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"sort"
+
+	orderedmap "github.com/wk8/go-ordered-map/v2"
+)
+
+var ErrNotSupported = errors.New("operation not supported on this platform")
+
+// Backend is implemented once per operating system, in a file built only for
+// it, and registered in the matching backend_<os>.go file, so the UI never has
+// to branch on runtime.GOOS. The parsers the implementations share with tests
+// live in untagged <os>_parse.go files.
+type Backend interface {
+	ListDisks(ctx context.Context) (*orderedmap.OrderedMap[string, Disk], error)
+	Mount(ctx context.Context, partition Partition, options MountOptions) (Partition, error)
+	Unmount(ctx context.Context, partition Partition) (Partition, error)
+	Eject(ctx context.Context, disk Disk) error
+	// Unlock unlocks an encrypted volume with passphrase, which may also be a
+	// recovery key, and mounts it. The passphrase is handed to the tools on
+	// standard input, never on their command line.
+	Unlock(ctx context.Context, partition Partition, passphrase string) error
+	// Lock unmounts and locks an unlocked encrypted partition, which on Linux
+	// is the LUKS container rather than the volume inside it.
+	Lock(ctx context.Context, partition Partition) error
+	// Filesystems lists the Filesystem constants FormatDisk and
+	// FormatPartition can create.
+	Filesystems() []string
+	// FormatDisk erases the whole disk and creates a single partition
+	// spanning it.
+	FormatDisk(ctx context.Context, disk Disk, options FormatOptions) error
+	FormatPartition(ctx context.Context, partition Partition, options FormatOptions) error
+	// Rename changes the label of a volume, after checking it with
+	// validateLabel.
+	Rename(ctx context.Context, partition Partition, label string) error
+	// Verify checks the filesystem of a volume without changing it, and
+	// Repair fixes what it finds. Both hand every line the tool prints to
+	// output while it runs, on another goroutine.
+	Verify(ctx context.Context, partition Partition, output func(line string)) error
+	Repair(ctx context.Context, partition Partition, output func(line string)) error
+	// PartitionTable reads the layout of the disk for the partition editor,
+	// and ApplyPlan carries out the operations planned for it in order.
+	// CheckOperation reports why ApplyPlan could not carry out op on table,
+	// so the editor refuses it as soon as it is queued.
+	PartitionTable(ctx context.Context, disk Disk) (PartitionTable, error)
+	CheckOperation(table PartitionTable, op Operation) error
+	ApplyPlan(ctx context.Context, disk Disk, plan *Plan) error
+	// AttachImage attaches the disk image file at path, which then shows up
+	// as a disk of its own with Disk.Image set.
+	AttachImage(ctx context.Context, path string) error
+	DetachImage(ctx context.Context, disk Disk) error
+	Open(path string) error
+	Info(ctx context.Context, id string) (map[string]interface{}, error)
+	Watcher() Watcher
+}
+
+var backend Backend
+
+// MountOptions change how Backend.Mount mounts a partition. The zero value
+// lets the platform pick the mount point.
+type MountOptions struct {
+	// Path is an existing empty folder to mount the partition on instead of
+	// a drive letter or the platform's default location.
+	Path string
+	// Letter is the drive letter to use on Windows when it is free.
+	Letter string
+	// ReadOnly mounts the filesystem without write access.
+	ReadOnly bool
+}
+
+// Filesystems a disk or partition can be formatted with, as offered in the
+// format wizard.
+const (
+	FilesystemAPFS  = "APFS"
+	FilesystemHFS   = "HFS+"
+	FilesystemExFAT = "exFAT"
+	FilesystemFAT32 = "FAT32"
+	FilesystemNTFS  = "NTFS"
+	FilesystemExt4  = "ext4"
+)
+
+// FormatOptions describe what FormatDisk and FormatPartition create.
+type FormatOptions struct {
+	Filesystem string
+	Label      string
+	// Scheme is the partition scheme written to an erased disk, "GPT" or
+	// "MBR". Formatting a partition leaves the scheme alone.
+	Scheme string
+}
+
+func validateMountFolder(path string) error {
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return userErrorf("cannot mount on %s: %s", path, err)
+	}
+	if len(entries) > 0 {
+		return userErrorf("cannot mount on %s: the folder is not empty", path)
+	}
+	return nil
+}
+
+func sortedKeys(info map[string]interface{}) []string {
+	keys := make([]string, 0, len(info))
+	for key := range info {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,9 @@
+//go:build !darwin && !windows && !linux
+
+package main
+
+import orderedmap "github.com/wk8/go-ordered-map/v2"
+
+func init() {
+	backend = NewFakeBackend(orderedmap.New[string, Disk]())
+}
@@ -0,0 +1,5 @@
+package main
+
+func init() {
+	backend = windowsBackend{}
+}
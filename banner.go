@@ -0,0 +1,81 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/therecipe/qt/widgets"
+)
+
+// errorBanner is a dismissible strip shown inside a disk card when an
+// operation on that disk fails. The raw command output of a *CommandError is
+// available through its Details button.
+type errorBanner struct {
+	*widgets.QFrame
+	message *widgets.QLabel
+	details *widgets.QPushButton
+	err     error
+}
+
+func newErrorBanner() *errorBanner {
+	var (
+		frame         = widgets.NewQFrame(nil, 0)
+		layout        = widgets.NewQHBoxLayout2(frame)
+		message       = widgets.NewQLabel2("", nil, 0)
+		detailsButton = widgets.NewQPushButton2(tr("Details"), nil)
+		dismissButton = widgets.NewQPushButton2(tr("Dismiss"), nil)
+	)
+	frame.SetFrameShape(widgets.QFrame__StyledPanel)
+	frame.SetStyleSheet("QFrame { background-color: #f8d7da; } QLabel { color: #721c24; }")
+	message.SetWordWrap(true)
+	layout.AddWidget(message, 1, 0)
+	layout.AddWidget(detailsButton, 0, 0)
+	layout.AddWidget(dismissButton, 0, 0)
+
+	banner := &errorBanner{QFrame: frame, message: message, details: detailsButton}
+	detailsButton.ConnectClicked(func(bool) {
+		banner.showDetails()
+	})
+	dismissButton.ConnectClicked(func(bool) {
+		banner.Hide()
+	})
+	frame.Hide()
+	return banner
+}
+
+func (b *errorBanner) ShowError(err error) {
+	b.err = err
+	b.message.SetText(errorText(err))
+	var commandError *CommandError
+	b.details.SetVisible(errors.As(err, &commandError) && (commandError.Stderr != "" || commandError.Command != ""))
+	b.Show()
+}
+
+func (b *errorBanner) showDetails() {
+	var commandError *CommandError
+	if !errors.As(b.err, &commandError) {
+		return
+	}
+	box := widgets.NewQMessageBox2(widgets.QMessageBox__Critical, "Qartion", errorText(b.err), widgets.QMessageBox__Close, b, 0)
+	details := commandError.Stderr
+	if commandError.Command != "" {
+		details = fmt.Sprintf("$ %s\n\n%s", commandError.Command, commandError.Stderr)
+	}
+	box.SetDetailedText(details)
+	box.Exec()
+	box.DeleteLater()
+}
+
+// errorText is err.Error() with the error kind in the user's language. The
+// operation stays as the backend named it.
+func errorText(err error) string {
+	var commandError *CommandError
+	if errors.As(err, &commandError) && commandError.Kind != KindUnknown {
+		return commandError.Op + ": " + tr(commandError.Kind.String())
+	}
+	var userError *userError
+	if errors.As(err, &userError) {
+		return userError.Text()
+	}
+	return err.Error()
+}
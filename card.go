@@ -0,0 +1,416 @@
+package main
+
+import (
+	"context"
+
+	"github.com/therecipe/qt/core"
+	"github.com/therecipe/qt/gui"
+	"github.com/therecipe/qt/widgets"
+	orderedmap "github.com/wk8/go-ordered-map/v2"
+)
+
+// diskCard is the group box showing a disk and its partitions. Update changes
+// its labels and rows in place, so a reload does not rebuild it.
+type diskCard struct {
+	*widgets.QGroupBox
+	disk        Disk
+	list        *widgets.QGridLayout
+	layout      *widgets.QGridLayout
+	name        *widgets.QLabel
+	size        *widgets.QLabel
+	ejectButton *widgets.QPushButton
+	banner      *errorBanner
+	rows        *orderedmap.OrderedMap[string, *partitionRow]
+}
+
+type partitionRow struct {
+	partition Partition
+	name      *widgets.QLabel
+	// nameEdit takes the place of name while the volume is renamed
+	nameEdit      *widgets.QLineEdit
+	renaming      bool
+	usage         *widgets.QProgressBar
+	size          *widgets.QLabel
+	mountButton   *widgets.QPushButton
+	unmountButton *widgets.QPushButton
+}
+
+// cards holds the card shown for every disk, keyed by disk ID.
+var cards = orderedmap.New[string, *diskCard]()
+
+// shown reports whether the card is still on screen. A background task may
+// finish after the disk it was started for went away.
+func (card *diskCard) shown() bool {
+	current, ok := cards.Get(card.disk.ID)
+	return ok && current == card
+}
+
+// run runs work for the card in the background and greys the card out
+// meanwhile. The disks are listed again afterwards, whether work failed or
+// not, and the cards are updated to match.
+func (card *diskCard) run(label string, work func(ctx context.Context) error) {
+	card.SetEnabled(false)
+	runTask(label, operationTimeout, card.finish(work))
+}
+
+// runUninterrupted is run for operations that rewrite a disk or volume, which
+// are left to finish however long they take.
+func (card *diskCard) runUninterrupted(label string, work func(ctx context.Context) error) {
+	card.SetEnabled(false)
+	runUninterruptedTask(label, card.finish(work))
+}
+
+// finish wraps work into a task that lists the disks again afterwards and
+// updates the cards.
+func (card *diskCard) finish(work func(ctx context.Context) error) func(ctx context.Context) func() {
+	return func(ctx context.Context) func() {
+		err := work(ctx)
+		disks, listErr := listDisks(ctx)
+		if err == nil {
+			err = listErr
+		}
+		return func() {
+			if !card.shown() {
+				return
+			}
+			card.SetEnabled(true)
+			if listErr == nil {
+				applyDisks(card.list, disks, nil)
+			}
+			if err != nil && card.shown() {
+				card.showError(err)
+			}
+		}
+	}
+}
+
+// showError shows err on the card, or above the tree when the tree view
+// hides the cards. A volume that failed to mount because it is damaged is
+// offered for repair.
+func (card *diskCard) showError(err error) {
+	if views != nil && views.CurrentIndex() != viewCards {
+		listBanner.ShowError(err)
+	} else {
+		card.banner.ShowError(err)
+	}
+	card.offerRepair(err)
+}
+
+func (card *diskCard) open(path string) {
+	if err := backend.Open(path); err != nil {
+		card.showError(err)
+	}
+}
+
+func (card *diskCard) mount(partition Partition, options MountOptions) {
+	volume := appSettings().Volume(volumeKey(partition))
+	options = volume.Apply(options)
+	card.run(trf("Mounting %s…", partitionDisplayName(partition)), func(ctx context.Context) error {
+		mounted, err := backend.Mount(ctx, partition, options)
+		if err != nil {
+			return &mountError{partition: partition, err: err}
+		}
+		if volume.OpenAfterMount {
+			return backend.Open(mounted.MountPoint)
+		}
+		return nil
+	})
+}
+
+func (card *diskCard) unmount(partition Partition) {
+	card.run(trf("Unmounting %s…", partitionDisplayName(partition)), func(ctx context.Context) error {
+		_, err := backend.Unmount(ctx, partition)
+		return err
+	})
+}
+
+// eject ejects the disk, or detaches it when it is a disk image.
+func (card *diskCard) eject() {
+	disk := card.disk
+	if disk.Image != "" {
+		card.run(trf("Detaching %s…", disk.Name), func(ctx context.Context) error {
+			return backend.DetachImage(ctx, disk)
+		})
+		return
+	}
+	card.run(trf("Ejecting %s…", disk.Name), func(ctx context.Context) error {
+		return backend.Eject(ctx, disk)
+	})
+}
+
+// ejectTitle names what eject does for the disk.
+func (card *diskCard) ejectTitle() string {
+	if card.disk.Image != "" {
+		return tr("Detach")
+	}
+	return tr("Eject")
+}
+
+func newDiskCard(l *widgets.QGridLayout, disk Disk) *diskCard {
+	var (
+		group       = widgets.NewQGroupBox2("", nil)
+		diskFont    = gui.NewQFont()
+		diskName    = widgets.NewQLabel2("", nil, 0)
+		diskSize    = widgets.NewQLabel2("", nil, 0)
+		ejectButton = widgets.NewQPushButton2("", nil)
+		layout      = widgets.NewQGridLayout2()
+		card        = &diskCard{
+			QGroupBox:   group,
+			list:        l,
+			layout:      layout,
+			name:        diskName,
+			size:        diskSize,
+			ejectButton: ejectButton,
+			banner:      newErrorBanner(),
+			rows:        orderedmap.New[string, *partitionRow](),
+		}
+	)
+	diskFont.SetPointSize(25)
+	diskName.SetFont(diskFont)
+	diskSize.SetFont(diskFont)
+
+	layout.AddWidget2(diskName, 0, 0, 0)
+	layout.AddWidget2(diskSize, 0, 100, 0)
+	layout.AddWidget2(ejectButton, 0, 101, core.Qt__AlignRight)
+	layout.AddWidget3(card.banner, 1, 0, 1, -1, 0)
+
+	ejectButton.ConnectClicked(func(bool) {
+		card.eject()
+	})
+
+	card.SetContextMenuPolicy(core.Qt__CustomContextMenu)
+	card.ConnectCustomContextMenuRequested(func(pos *core.QPoint) {
+		card.contextMenu(pos)
+	})
+
+	card.SetLayout(layout)
+	card.Update(disk)
+	return card
+}
+
+// Update shows disk on the card, adding, updating and removing partition
+// rows as needed. Cards show one flat row per volume, whatever containers
+// they are nested in.
+func (card *diskCard) Update(disk Disk) {
+	card.disk = disk
+	card.name.SetText(disk.Name)
+	card.name.SetToolTip(disk.Image)
+	card.size.SetText(formatSize(disk.Size))
+	card.ejectButton.SetText(card.ejectTitle())
+
+	volumes := disk.Volumes()
+	wanted := make(map[string]bool, len(volumes))
+	for _, partition := range volumes {
+		wanted[partition.ID] = true
+	}
+	for pair := card.rows.Oldest(); pair != nil; {
+		next := pair.Next()
+		if !wanted[pair.Key] {
+			card.removeRow(pair.Value)
+			card.rows.Delete(pair.Key)
+		}
+		pair = next
+	}
+	for _, partition := range volumes {
+		row, ok := card.rows.Get(partition.ID)
+		if !ok {
+			row = card.addRow()
+			card.rows.Set(partition.ID, row)
+		}
+		row.Update(partition)
+	}
+}
+
+func (card *diskCard) addRow() *partitionRow {
+	var (
+		partitionFont = gui.NewQFont()
+		index         = card.layout.RowCount()
+		row           = &partitionRow{
+			name:          widgets.NewQLabel2("", nil, 0),
+			nameEdit:      widgets.NewQLineEdit(nil),
+			usage:         widgets.NewQProgressBar(nil),
+			size:          widgets.NewQLabel2("", nil, 0),
+			mountButton:   widgets.NewQPushButton2("", nil),
+			unmountButton: widgets.NewQPushButton2(tr("Unmount"), nil),
+		}
+	)
+	partitionFont.SetPointSize(15)
+	row.name.SetFont(partitionFont)
+	row.nameEdit.SetFont(partitionFont)
+	row.nameEdit.Hide()
+	row.size.SetFont(partitionFont)
+	// per mille, so that small volumes still move the bar
+	row.usage.SetRange(0, 1000)
+
+	card.layout.AddWidget2(row.name, index, 0, 0)
+	card.layout.AddWidget2(row.nameEdit, index, 0, 0)
+	card.layout.AddWidget2(row.usage, index, 1, 0)
+	card.layout.AddWidget2(row.size, index, 2, core.Qt__AlignRight)
+	card.layout.AddWidget2(row.mountButton, index, 3, core.Qt__AlignRight)
+	card.layout.AddWidget2(row.unmountButton, index, 4, core.Qt__AlignRight)
+
+	// the handlers read row.partition, which Update keeps current
+	row.mountButton.ConnectClicked(func(bool) {
+		switch {
+		case row.partition.MountPoint != "":
+			card.open(row.partition.MountPoint)
+		case row.partition.Locked:
+			card.unlock(row.partition)
+		default:
+			card.mount(row.partition, MountOptions{})
+		}
+	})
+	row.unmountButton.ConnectClicked(func(bool) {
+		card.unmount(row.partition)
+	})
+
+	// the label is renamed in place, after a double-click on it or Rename…
+	// from the menu. Enter or leaving the field keeps the new label and
+	// Escape the old one.
+	row.name.ConnectMouseDoubleClickEvent(func(event *gui.QMouseEvent) {
+		if renamable(row.partition) {
+			row.startRename()
+		}
+	})
+	row.nameEdit.ConnectEditingFinished(func() {
+		card.finishRename(row, true)
+	})
+	cancel := widgets.NewQAction(row.nameEdit)
+	cancel.SetShortcut(gui.NewQKeySequence5(gui.QKeySequence__Cancel))
+	cancel.SetShortcutContext(core.Qt__WidgetShortcut)
+	cancel.ConnectTriggered(func(bool) {
+		card.finishRename(row, false)
+	})
+	row.nameEdit.QWidget.AddAction(cancel)
+	return row
+}
+
+func (card *diskCard) removeRow(row *partitionRow) {
+	for _, widget := range []widgets.QWidget_ITF{row.name, row.nameEdit, row.usage, row.size, row.mountButton, row.unmountButton} {
+		card.layout.RemoveWidget(widget)
+		widget.QWidget_PTR().DeleteLater()
+	}
+}
+
+func (row *partitionRow) Update(partition Partition) {
+	row.partition = partition
+	row.name.SetText(partitionDisplayName(partition))
+	row.size.SetText(formatSize(partition.Size))
+	if partition.MountPoint != "" && partition.ReadOnly {
+		row.mountButton.SetText(trf("%s (read-only)", partition.MountPoint))
+	} else if partition.MountPoint != "" {
+		row.mountButton.SetText(partition.MountPoint)
+	} else if partition.Locked {
+		row.mountButton.SetText(tr("Unlock…"))
+	} else {
+		row.mountButton.SetText(tr("Mount"))
+	}
+	row.unmountButton.SetVisible(partition.MountPoint != "")
+
+	total := partition.Free + partition.Used
+	row.usage.SetVisible(total > 0)
+	if total == 0 {
+		return
+	}
+	row.usage.SetValue(int(partition.Used * 1000 / total))
+	row.usage.SetFormat(trf("%s free", formatSize(partition.Free)))
+	row.usage.SetToolTip(trf("%s used, %s free", formatSize(partition.Used), formatSize(partition.Free)))
+	if lowOnSpace(partition, appSettings().LowSpaceThreshold()) {
+		row.usage.SetStyleSheet("QProgressBar::chunk { background-color: #d9534f; }")
+	} else {
+		row.usage.SetStyleSheet("")
+	}
+}
+
+func (card *diskCard) contextMenu(pos *core.QPoint) {
+	menu := widgets.NewQMenu(card)
+	for _, partition := range card.disk.Volumes() {
+		row, _ := card.rows.Get(partition.ID)
+		card.addPartitionActions(menu.AddMenu2(partitionDisplayName(partition)), partition, row.startRename)
+	}
+	menu.AddSeparator()
+	card.addDiskActions(menu)
+	menu.Exec2(card.MapToGlobal(pos), nil)
+	menu.DeleteLater()
+}
+
+// addDiskActions adds the actions available for the whole disk to menu.
+func (card *diskCard) addDiskActions(menu *widgets.QMenu) {
+	menu.AddAction(tr("Properties…")).ConnectTriggered(func(bool) {
+		showProperties(card.disk.Name, card.disk.ID, card.showError)
+	})
+	menu.AddAction(tr("Edit Partitions…")).ConnectTriggered(func(bool) {
+		card.editPartitions()
+	})
+	format := menu.AddAction(tr("Erase…"))
+	format.SetEnabled(!isBootDisk(card.disk))
+	format.ConnectTriggered(func(bool) {
+		card.formatDisk()
+	})
+	menu.AddAction(card.ejectTitle()).ConnectTriggered(func(bool) {
+		card.eject()
+	})
+}
+
+// addPartitionActions adds the actions available for partition to menu. The
+// tree view uses them too, and passes its own rename, which like the card's
+// edits the label in place.
+func (card *diskCard) addPartitionActions(menu *widgets.QMenu, partition Partition, rename func()) {
+	if partition.Locked {
+		menu.AddAction(tr("Unlock…")).ConnectTriggered(func(bool) {
+			card.unlock(partition)
+		})
+	} else if partition.MountPoint != "" {
+		menu.AddAction(tr("Open")).ConnectTriggered(func(bool) {
+			card.open(partition.MountPoint)
+		})
+		menu.AddAction(tr("Unmount")).ConnectTriggered(func(bool) {
+			card.unmount(partition)
+		})
+	} else {
+		menu.AddAction(tr("Mount")).ConnectTriggered(func(bool) {
+			card.mount(partition, MountOptions{})
+		})
+		menu.AddAction(tr("Mount Read-Only")).ConnectTriggered(func(bool) {
+			card.mount(partition, MountOptions{ReadOnly: true})
+		})
+		menu.AddAction(tr("Mount to Folder…")).ConnectTriggered(func(bool) {
+			path := widgets.QFileDialog_GetExistingDirectory(window, tr("Choose an empty folder"), "", widgets.QFileDialog__ShowDirsOnly)
+			if path != "" {
+				card.mount(partition, MountOptions{Path: path})
+			}
+		})
+	}
+	if _, ok := lockablePartition(card.disk.Partitions, partition.ID); ok {
+		menu.AddAction(tr("Lock")).ConnectTriggered(func(bool) {
+			card.lock(partition.ID)
+		})
+	}
+	renameAction := menu.AddAction(tr("Rename…"))
+	renameAction.SetEnabled(renamable(partition))
+	renameAction.ConnectTriggered(func(bool) {
+		rename()
+	})
+	verify := menu.AddAction(tr("Verify"))
+	verify.SetEnabled(!partition.Locked)
+	verify.ConnectTriggered(func(bool) {
+		card.check(partition, false)
+	})
+	repair := menu.AddAction(tr("Repair"))
+	repair.SetEnabled(!partition.Locked && !isBootDisk(card.disk))
+	repair.ConnectTriggered(func(bool) {
+		card.check(partition, true)
+	})
+	format := menu.AddAction(tr("Format…"))
+	format.SetEnabled(!isBootDisk(card.disk))
+	format.ConnectTriggered(func(bool) {
+		card.formatPartition(partition)
+	})
+	menu.AddSeparator()
+	menu.AddAction(tr("Preferences…")).ConnectTriggered(func(bool) {
+		showVolumeSettings(window, partition)
+	})
+	menu.AddAction(tr("Properties…")).ConnectTriggered(func(bool) {
+		showProperties(partitionDisplayName(partition), partition.ID, card.showError)
+	})
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"text/tabwriter"
+
+	orderedmap "github.com/wk8/go-ordered-map/v2"
+)
+
+const cliUsage = `Usage: qartion [command] [--json] [--timeout <duration>]
+
+Without a command the graphical interface is started.
+
+Commands:
+  list          list disks and their partitions
+  mount <id>    mount a partition, on an empty folder with --path <folder>,
+                without write access with --read-only
+  unmount <id>  unmount a partition
+  open <id>     open the mount point of a partition
+  info <id>     show everything the platform knows about a disk or partition
+
+A partition can be referred to by its ID, device, UUID or mount point.
+`
+
+// cliSettings are the preferences the subcommands honour: the size format and
+// the saved mount options of each volume.
+type cliSettings interface {
+	SizeFormat() SizeFormat
+	Volume(key string) VolumeSettings
+}
+
+// openCLISettings opens the settings for the subcommands. They are kept with
+// QSettings, so reading them still loads QtCore, although no QApplication is
+// created and no window is shown. Tests replace it to stay clear of Qt.
+var openCLISettings = func() cliSettings {
+	return appSettings()
+}
+
+// runCLI handles the headless subcommands without starting the GUI. It
+// reports false when args do not start with a subcommand so main can start
+// the GUI.
+func runCLI(args []string, stdout, stderr io.Writer) (int, bool) {
+	if len(args) == 0 {
+		return 0, false
+	}
+	command := args[0]
+	switch command {
+	case "list", "mount", "unmount", "open", "info":
+	case "help", "-h", "-help", "--help":
+		fmt.Fprint(stdout, cliUsage)
+		return 0, true
+	default:
+		return 0, false
+	}
+
+	fs := flag.NewFlagSet(command, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprint(stderr, cliUsage)
+	}
+	jsonOutput := fs.Bool("json", false, "print JSON instead of text")
+	path := fs.String("path", "", "mount on this empty folder")
+	readOnly := fs.Bool("read-only", false, "mount without write access")
+	timeout := fs.Duration("timeout", 0, "give up after this long, for example 30s")
+	var positional []string
+	rest := args[1:]
+	for {
+		if err := fs.Parse(rest); err != nil {
+			return 2, true
+		}
+		if fs.NArg() == 0 {
+			break
+		}
+		positional = append(positional, fs.Arg(0))
+		rest = fs.Args()[1:]
+	}
+
+	// Ctrl+C kills the command Qartion is waiting for instead of leaving it
+	// running after we exit
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	if *timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *timeout)
+		defer cancel()
+	}
+
+	if !*jsonOutput {
+		// JSON output has raw byte counts and no need for the settings
+		sizeFormat = openCLISettings().SizeFormat()
+	}
+
+	var err error
+	if command == "list" {
+		err = cliList(ctx, stdout, *jsonOutput)
+	} else if len(positional) != 1 {
+		fmt.Fprintf(stderr, "%s expects exactly one id\n\n%s", command, cliUsage)
+		return 2, true
+	} else {
+		err = cliRun(ctx, stdout, command, positional[0], MountOptions{Path: *path, ReadOnly: *readOnly}, *jsonOutput)
+	}
+	if err != nil {
+		fmt.Fprintln(stderr, "Error:", err)
+		return 1, true
+	}
+	return 0, true
+}
+
+func cliList(ctx context.Context, stdout io.Writer, jsonOutput bool) error {
+	disks, err := backend.ListDisks(ctx)
+	if err != nil {
+		return err
+	}
+	if jsonOutput {
+		return cliWriteJSON(stdout, disks)
+	}
+	w := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tNAME\tSIZE\tFREE\tMOUNT POINT")
+	for pair := disks.Oldest(); pair != nil; pair = pair.Next() {
+		disk := pair.Value
+		fmt.Fprintf(w, "%s\t%s\t%s\t\t\n", disk.ID, disk.Name, formatSize(disk.Size))
+		cliListPartitions(w, disk.Partitions, "  ")
+	}
+	return w.Flush()
+}
+
+func cliListPartitions(w io.Writer, partitions *orderedmap.OrderedMap[string, Partition], indent string) {
+	if partitions == nil {
+		return
+	}
+	for p := partitions.Oldest(); p != nil; p = p.Next() {
+		partition := p.Value
+		free := ""
+		if partition.Free+partition.Used > 0 {
+			free = formatSize(partition.Free)
+		}
+		fmt.Fprintf(w, "%s%s\t%s\t%s\t%s\t%s\n", indent, partition.ID, partitionDisplayName(partition), formatSize(partition.Size), free, partition.MountPoint)
+		cliListPartitions(w, partition.Partitions, indent+"  ")
+	}
+}
+
+func cliRun(ctx context.Context, stdout io.Writer, command string, id string, options MountOptions, jsonOutput bool) error {
+	disks, err := backend.ListDisks(ctx)
+	if err != nil {
+		return err
+	}
+	if command == "info" {
+		if disk, ok := findDisk(disks, id); ok {
+			id = disk.ID
+		} else if _, partition, ok := findPartition(disks, id); ok {
+			id = partition.ID
+		}
+		info, err := backend.Info(ctx, id)
+		if err != nil {
+			return err
+		}
+		if jsonOutput {
+			return cliWriteJSON(stdout, info)
+		}
+		return cliWriteInfo(stdout, info)
+	}
+
+	_, partition, ok := findPartition(disks, id)
+	if !ok {
+		return fmt.Errorf("no partition matches %q", id)
+	}
+	switch command {
+	case "mount":
+		if partition.MountPoint == "" {
+			options = openCLISettings().Volume(volumeKey(partition)).Apply(options)
+			partition, err = backend.Mount(ctx, partition, options)
+		}
+	case "unmount":
+		if partition.MountPoint != "" {
+			partition, err = backend.Unmount(ctx, partition)
+		}
+	case "open":
+		if partition.MountPoint == "" {
+			return fmt.Errorf("%s is not mounted", partition.ID)
+		}
+		return backend.Open(partition.MountPoint)
+	}
+	if err != nil {
+		return err
+	}
+	if jsonOutput {
+		return cliWriteJSON(stdout, partition)
+	}
+	if partition.MountPoint != "" {
+		fmt.Fprintln(stdout, partition.MountPoint)
+	}
+	return nil
+}
+
+func cliWriteJSON(stdout io.Writer, v interface{}) error {
+	encoder := json.NewEncoder(stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
+
+func cliWriteInfo(stdout io.Writer, info map[string]interface{}) error {
+	w := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+	for _, key := range sortedKeys(info) {
+		fmt.Fprintf(w, "%s:\t%v\n", key, info[key])
+	}
+	return w.Flush()
+}
+
+func findDisk(disks *orderedmap.OrderedMap[string, Disk], id string) (Disk, bool) {
+	for pair := disks.Oldest(); pair != nil; pair = pair.Next() {
+		disk := pair.Value
+		if disk.ID == id || disk.Device == id {
+			return disk, true
+		}
+	}
+	return Disk{}, false
+}
+
+func findPartition(disks *orderedmap.OrderedMap[string, Disk], id string) (Disk, Partition, bool) {
+	for pair := disks.Oldest(); pair != nil; pair = pair.Next() {
+		disk := pair.Value
+		for _, partition := range disk.Volumes() {
+			if id == partition.ID || id == partition.Device || (partition.UUID != "" && id == partition.UUID) || (partition.MountPoint != "" && id == partition.MountPoint) {
+				return disk, partition, true
+			}
+		}
+	}
+	return Disk{}, Partition{}, false
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	orderedmap "github.com/wk8/go-ordered-map/v2"
+)
+
+// fakeSettings stands in for QSettings, which the tests cannot load.
+type fakeSettings struct {
+	sizeFormat SizeFormat
+	volumes    map[string]VolumeSettings
+}
+
+func (s fakeSettings) SizeFormat() SizeFormat {
+	return s.sizeFormat
+}
+
+func (s fakeSettings) Volume(key string) VolumeSettings {
+	return s.volumes[key]
+}
+
+// fakeDisks returns an internal disk with an unlocked encrypted partition,
+// whose volume is nested in it, and a USB stick that is mounted.
+func fakeDisks() *orderedmap.OrderedMap[string, Disk] {
+	data := orderedmap.New[string, Partition]()
+	data.Set("disk0s2v1", Partition{ID: "disk0s2v1", Name: "Data", Size: 400e9, Device: "disk0s2v1", UUID: "1234-ABCD", Filesystem: FilesystemExt4})
+	internal := orderedmap.New[string, Partition]()
+	internal.Set("disk0s1", Partition{ID: "disk0s1", Name: "EFI", Size: 200e6, Device: "disk0s1", Filesystem: FilesystemFAT32})
+	internal.Set("disk0s2", Partition{ID: "disk0s2", Size: 400e9, Device: "disk0s2", Partitions: data, Encrypted: true})
+	stick := orderedmap.New[string, Partition]()
+	stick.Set("disk1s1", Partition{ID: "disk1s1", Name: "STICK", Size: 16e9, Device: "disk1s1", Filesystem: FilesystemExFAT, MountPoint: "/fake/disk1s1", Free: 12e9, Used: 4e9})
+
+	disks := orderedmap.New[string, Disk]()
+	disks.Set("disk0", Disk{ID: "disk0", Name: "Internal", Size: 500e9, Device: "disk0", Partitions: internal})
+	disks.Set("disk1", Disk{ID: "disk1", Name: "USB", Size: 16e9, Device: "disk1", Partitions: stick})
+	return disks
+}
+
+// runFakeCLI runs the command line against fake and returns the exit code and
+// what was printed.
+func runFakeCLI(t *testing.T, fake *FakeBackend, settings fakeSettings, args ...string) (int, string, string) {
+	t.Helper()
+	savedBackend, savedSettings, savedFormat := backend, openCLISettings, sizeFormat
+	t.Cleanup(func() {
+		backend, openCLISettings, sizeFormat = savedBackend, savedSettings, savedFormat
+	})
+	backend = fake
+	openCLISettings = func() cliSettings {
+		return settings
+	}
+	var stdout, stderr bytes.Buffer
+	code, ok := runCLI(args, &stdout, &stderr)
+	if !ok {
+		t.Fatalf("runCLI(%q) did not handle the command", args)
+	}
+	return code, stdout.String(), stderr.String()
+}
+
+func TestCLIList(t *testing.T) {
+	settings := fakeSettings{sizeFormat: SizeFormat{Units: UnitsSI, Decimals: 1, DecimalSeparator: ",", GroupSeparator: "."}}
+	code, stdout, stderr := runFakeCLI(t, NewFakeBackend(fakeDisks()), settings, "list")
+	if code != 0 {
+		t.Fatalf("exit code %d, stderr %q", code, stderr)
+	}
+	want := `ID             NAME       SIZE      FREE     MOUNT POINT
+disk0          Internal   500,0 GB
+  disk0s1      EFI        200,0 MB
+  disk0s2      (No Name)  400,0 GB
+    disk0s2v1  Data       400,0 GB
+disk1          USB        16,0 GB
+  disk1s1      STICK      16,0 GB   12,0 GB  /fake/disk1s1
+`
+	// tabwriter pads the empty columns at the end of the disk rows
+	var lines []string
+	for _, line := range strings.Split(stdout, "\n") {
+		lines = append(lines, strings.TrimRight(line, " "))
+	}
+	if got := strings.Join(lines, "\n"); got != want {
+		t.Errorf("list printed\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestCLIListJSON(t *testing.T) {
+	code, stdout, stderr := runFakeCLI(t, NewFakeBackend(fakeDisks()), fakeSettings{}, "list", "--json")
+	if code != 0 {
+		t.Fatalf("exit code %d, stderr %q", code, stderr)
+	}
+	disks := orderedmap.New[string, Disk]()
+	if err := json.Unmarshal([]byte(stdout), disks); err != nil {
+		t.Fatalf("list --json printed invalid JSON: %v\n%s", err, stdout)
+	}
+	if keys := disks.Len(); keys != 2 {
+		t.Fatalf("got %d disks, want 2", keys)
+	}
+	internal, _ := disks.Get("disk0")
+	container, _ := internal.Partitions.Get("disk0s2")
+	if data, ok := container.Partitions.Get("disk0s2v1"); !ok || data.Size != 400e9 || data.UUID != "1234-ABCD" {
+		t.Errorf("nested volume = %+v, want disk0s2v1 with its raw size and UUID", data)
+	}
+}
+
+func TestCLIMount(t *testing.T) {
+	settings := fakeSettings{volumes: map[string]VolumeSettings{
+		"1234-ABCD": {MountPoint: "/media/data", ReadOnly: true},
+	}}
+	tests := []struct {
+		name string
+		args []string
+		want string
+	}{
+		// the nested volume is found by its UUID and mounted where its
+		// settings say
+		{"saved options", []string{"mount", "1234-ABCD"}, "/media/data\n"},
+		{"path", []string{"mount", "disk0s2v1", "--path", "/mnt/data"}, "/mnt/data\n"},
+		{"device", []string{"mount", "disk0s1"}, "/fake/disk0s1\n"},
+		// mounting a mounted volume only prints where it is
+		{"mounted", []string{"mount", "/fake/disk1s1"}, "/fake/disk1s1\n"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			code, stdout, stderr := runFakeCLI(t, NewFakeBackend(fakeDisks()), settings, test.args...)
+			if code != 0 {
+				t.Fatalf("exit code %d, stderr %q", code, stderr)
+			}
+			if stdout != test.want {
+				t.Errorf("printed %q, want %q", stdout, test.want)
+			}
+		})
+	}
+}
+
+func TestCLIMountJSON(t *testing.T) {
+	fake := NewFakeBackend(fakeDisks())
+	settings := fakeSettings{volumes: map[string]VolumeSettings{
+		"1234-ABCD": {ReadOnly: true},
+	}}
+	code, stdout, stderr := runFakeCLI(t, fake, settings, "mount", "disk0s2v1", "--json")
+	if code != 0 {
+		t.Fatalf("exit code %d, stderr %q", code, stderr)
+	}
+	var partition Partition
+	if err := json.Unmarshal([]byte(stdout), &partition); err != nil {
+		t.Fatalf("mount --json printed invalid JSON: %v\n%s", err, stdout)
+	}
+	if partition.ID != "disk0s2v1" || partition.MountPoint != "/fake/disk0s2v1" || !partition.ReadOnly {
+		t.Errorf("mount --json printed %+v, want disk0s2v1 mounted read-only", partition)
+	}
+	// the fake remembers the mount
+	if _, stored, _ := fake.find("disk0s2v1"); stored.MountPoint != "/fake/disk0s2v1" {
+		t.Errorf("fake mount point = %q, want /fake/disk0s2v1", stored.MountPoint)
+	}
+}
+
+func TestCLIUnmount(t *testing.T) {
+	fake := NewFakeBackend(fakeDisks())
+	code, stdout, stderr := runFakeCLI(t, fake, fakeSettings{}, "unmount", "disk1s1")
+	if code != 0 || stdout != "" {
+		t.Fatalf("unmount exited with %d and printed %q, stderr %q", code, stdout, stderr)
+	}
+	if _, stored, _ := fake.find("disk1s1"); stored.MountPoint != "" {
+		t.Errorf("disk1s1 is still mounted on %s", stored.MountPoint)
+	}
+
+	code, stdout, stderr = runFakeCLI(t, fake, fakeSettings{}, "unmount", "disk1s1", "--json")
+	if code != 0 {
+		t.Fatalf("exit code %d, stderr %q", code, stderr)
+	}
+	var partition Partition
+	if err := json.Unmarshal([]byte(stdout), &partition); err != nil || partition.ID != "disk1s1" || partition.MountPoint != "" {
+		t.Errorf("unmount --json printed %q, want disk1s1 without a mount point", stdout)
+	}
+}
+
+func TestCLIInfo(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{"disk", []string{"info", "disk1"}, "ID:    disk1\nName:  USB\nSize:  16000000000\n"},
+		{"nested partition", []string{"info", "1234-ABCD"}, "Device:      disk0s2v1\nID:          disk0s2v1\nMountPoint:  \nName:        Data\nSize:        400000000000\n"},
+		{"json", []string{"info", "disk1", "--json"}, "{\n  \"ID\": \"disk1\",\n  \"Name\": \"USB\",\n  \"Size\": 16000000000\n}\n"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			code, stdout, stderr := runFakeCLI(t, NewFakeBackend(fakeDisks()), fakeSettings{}, test.args...)
+			if code != 0 {
+				t.Fatalf("exit code %d, stderr %q", code, stderr)
+			}
+			if stdout != test.want {
+				t.Errorf("printed %q, want %q", stdout, test.want)
+			}
+		})
+	}
+}
+
+func TestCLIErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		args   []string
+		code   int
+		stderr string
+	}{
+		{"unknown id", []string{"mount", "disk9"}, 1, `Error: no partition matches "disk9"`},
+		{"missing id", []string{"unmount"}, 2, "unmount expects exactly one id"},
+		{"two ids", []string{"info", "disk0", "disk1"}, 2, "info expects exactly one id"},
+		{"bad flag", []string{"list", "--bogus"}, 2, "flag provided but not defined"},
+		{"not mounted", []string{"open", "disk0s1"}, 1, "Error: disk0s1 is not mounted"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			code, _, stderr := runFakeCLI(t, NewFakeBackend(fakeDisks()), fakeSettings{}, test.args...)
+			if code != test.code || !strings.Contains(stderr, test.stderr) {
+				t.Errorf("exit code %d, stderr %q; want %d and %q", code, stderr, test.code, test.stderr)
+			}
+		})
+	}
+}
+
+func TestCLIOpen(t *testing.T) {
+	fake := NewFakeBackend(fakeDisks())
+	if code, _, stderr := runFakeCLI(t, fake, fakeSettings{}, "open", "disk1s1"); code != 0 {
+		t.Fatalf("exit code %d, stderr %q", code, stderr)
+	}
+	if len(fake.Opened) != 1 || fake.Opened[0] != "/fake/disk1s1" {
+		t.Errorf("opened %q, want [/fake/disk1s1]", fake.Opened)
+	}
+}
+
+func TestCLINotACommand(t *testing.T) {
+	for _, args := range [][]string{nil, {"-platform", "offscreen"}, {"--style=fusion"}} {
+		if _, ok := runCLI(args, &bytes.Buffer{}, &bytes.Buffer{}); ok {
+			t.Errorf("runCLI(%q) handled the arguments, want the GUI to start", args)
+		}
+	}
+}
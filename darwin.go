@@ -1,174 +1,409 @@
+//go:build darwin
+
 package main
 
 import (
+	"context"
 	"fmt"
 	"os/exec"
+	"strconv"
 	"strings"
 
-	"path/filepath"
-
 	"github.com/getlantern/elevate"
-	"github.com/google/uuid"
 	"github.com/oq-x/go-plist"
 	orderedmap "github.com/wk8/go-ordered-map/v2"
 )
 
-func darwinGetDiskPartitions() (*orderedmap.OrderedMap[string, Disk], error) {
-	cmd := exec.Command("diskutil", "list", "-plist")
+// darwinImages mirrors the output of `hdiutil info -plist`.
+type darwinImages struct {
+	Images []struct {
+		ImagePath      string `plist:"image-path"`
+		SystemEntities []struct {
+			DevEntry string `plist:"dev-entry"`
+		} `plist:"system-entities"`
+	} `plist:"images"`
+}
 
-	output, err := cmd.Output()
+// darwinAddImages marks the disks attached from disk images with the path of
+// their image, which also becomes their name.
+func darwinAddImages(ctx context.Context, disks *orderedmap.OrderedMap[string, Disk]) error {
+	output, err := runCommand(ctx, "list disk images", exec.Command("hdiutil", "info", "-plist"))
 	if err != nil {
-		return nil, fmt.Errorf("failed to execute diskutil command: %s", err)
+		return err
 	}
-	var data = plist.OrderedDict{}
-	_, err = plist.Unmarshal(output, &data)
-	if err != nil {
-		return nil, fmt.Errorf("failed to parse diskutil output: %s", err)
-	}
-
-	disks := orderedmap.New[string, Disk]()
-	all := data.Values[1].([]interface{})
-	for _, d := range all {
-		data := d.(plist.OrderedDict)
-		if len(data.Keys) == 5 {
-			// disk
-			id := uuid.NewString()
-			partitions := orderedmap.New[string, Partition]()
-			pars := data.Values[3].([]interface{})
-			for _, p := range pars {
-				par := p.(plist.OrderedDict)
-				switch len(par.Keys) {
-				case 6:
-					{
-						if par.Values[0] == "Microsoft Basic Data" {
-							id := par.Values[2].(string)
-							namesp := strings.Split(par.Values[3].(string), "/")
-							name := namesp[len(namesp)-1]
-							partitions.Set(id, Partition{
-								Name:       name,
-								Device:     par.Values[1].(string),
-								ID:         id,
-								Size:       par.Values[4].(uint64),
-								MountPoint: par.Values[3].(string),
-							})
-						} else {
-							id := par.Values[2].(string)
-							mountPoint := ""
-							if filepath.IsAbs(id) {
-								mountPoint = id
-								id = par.Values[5].(string)
-							}
-							partitions.Set(id, Partition{
-								Name:       par.Values[4].(string),
-								Device:     par.Values[1].(string),
-								ID:         id,
-								Size:       par.Values[3].(uint64),
-								MountPoint: mountPoint,
-							})
-						}
-					}
-				case 7:
-					{
-						partitions.Set(par.Values[2].(string), Partition{
-							Name:       par.Values[5].(string),
-							Device:     par.Values[1].(string),
-							ID:         par.Values[2].(string),
-							Size:       par.Values[4].(uint64),
-							MountPoint: par.Values[3].(string),
-						})
-					}
-				}
+	var info darwinImages
+	if _, err := plist.Unmarshal(output, &info); err != nil {
+		return parseError("list disk images", output, err)
+	}
+	for _, image := range info.Images {
+		for _, entity := range image.SystemEntities {
+			disk, ok := disks.Get(strings.TrimPrefix(entity.DevEntry, "/dev/"))
+			if !ok {
+				continue
 			}
-			info, _ := GetInfo(data.Values[1].(string))
-			disks.Set(info["MediaName"].(string), Disk{
-				ID:         id,
-				Name:       info["MediaName"].(string),
-				Size:       data.Values[4].(uint64),
-				Partitions: partitions,
-			})
-		} else if len(data.Keys) == 7 {
-			// container
-			info, _ := GetInfo(data.Values[3].(string))
-			disk, e := disks.Get(info["MediaName"].(string))
-			if e {
-				pars := data.Values[1].([]interface{})
-				for _, i := range pars {
-					par := i.(plist.OrderedDict)
-					switch len(par.Keys) {
-					case 8:
-						{
-							if par.Keys[3] == "MountPoint" {
-								disk.Partitions.Set(par.Values[2].(string), Partition{
-									Name:       par.Values[6].(string),
-									Device:     par.Values[1].(string),
-									ID:         par.Values[2].(string),
-									Size:       par.Values[5].(uint64),
-									MountPoint: par.Values[3].(string),
-								})
-							}
-						}
-					case 9:
-						{
-							disk.Partitions.Set(par.Values[2].(string), Partition{
-								Name:       par.Values[7].(string),
-								Device:     par.Values[1].(string),
-								ID:         par.Values[2].(string),
-								Size:       par.Values[6].(uint64),
-								MountPoint: par.Values[3].(string),
-							})
-						}
-					}
-				}
+			disk.Image = image.ImagePath
+			disk.Name = imageName(image.ImagePath)
+			disks.Set(disk.ID, disk)
+		}
+	}
+	return nil
+}
+
+// darwinAPFSList mirrors the output of `diskutil apfs list -plist`, which
+// unlike `diskutil list` tells whether a volume is locked.
+type darwinAPFSList struct {
+	Containers []struct {
+		Volumes []struct {
+			DeviceIdentifier string `plist:"DeviceIdentifier"`
+			Encryption       bool   `plist:"Encryption"`
+			Locked           bool   `plist:"Locked"`
+		} `plist:"Volumes"`
+	} `plist:"Containers"`
+}
+
+// darwinAddEncryption marks the encrypted APFS volumes, and which of them are
+// locked.
+func darwinAddEncryption(ctx context.Context, disks *orderedmap.OrderedMap[string, Disk]) error {
+	output, err := runCommand(ctx, "list APFS volumes", exec.Command("diskutil", "apfs", "list", "-plist"))
+	if err != nil {
+		return err
+	}
+	var list darwinAPFSList
+	if _, err := plist.Unmarshal(output, &list); err != nil {
+		return parseError("list APFS volumes", output, err)
+	}
+	encrypted := make(map[string]bool)
+	locked := make(map[string]bool)
+	for _, container := range list.Containers {
+		for _, volume := range container.Volumes {
+			encrypted[volume.DeviceIdentifier] = volume.Encryption
+			locked[volume.DeviceIdentifier] = volume.Locked
+		}
+	}
+	for pair := disks.Oldest(); pair != nil; pair = pair.Next() {
+		for _, partition := range pair.Value.Volumes() {
+			if !encrypted[partition.Device] {
+				continue
 			}
+			partition.Encrypted = true
+			partition.Locked = locked[partition.Device]
+			setPartition(pair.Value.Partitions, partition)
 		}
 	}
-	return disks, nil
+	return nil
+}
+
+func darwinGetDiskPartitions(ctx context.Context) (*orderedmap.OrderedMap[string, Disk], error) {
+	output, err := runCommand(ctx, "list disks", exec.Command("diskutil", "list", "-plist"))
+	if err != nil {
+		return nil, err
+	}
+	list, err := darwinParseList(output)
+	if err != nil {
+		return nil, err
+	}
+	return darwinBuildDisks(list, func(id string) (string, error) {
+		info, err := DarwinGetInfo(ctx, id)
+		if err != nil {
+			return "", err
+		}
+		if info.MediaName != "" {
+			return strings.TrimSpace(info.MediaName), nil
+		}
+		return info.IORegistryEntryName, nil
+	})
 }
 
-func GetInfo(name string) (map[string]interface{}, error) {
-	cmd := exec.Command("diskutil", "info", "-plist", name)
+func darwinInfoOutput(ctx context.Context, name string) ([]byte, error) {
+	return runCommand(ctx, "get info for "+name, exec.Command("diskutil", "info", "-plist", name))
+}
 
-	output, err := cmd.Output()
+func DarwinGetInfo(ctx context.Context, name string) (DarwinInfo, error) {
+	output, err := darwinInfoOutput(ctx, name)
 	if err != nil {
-		return nil, fmt.Errorf("failed to execute diskutil command: %s", err)
+		return DarwinInfo{}, err
 	}
-	var data = plist.OrderedDict{}
-	d := make(map[string]interface{})
-	_, err = plist.Unmarshal(output, &data)
+	return darwinParseInfo(name, output)
+}
+
+// GetInfo returns every key of `diskutil info -plist`, including the ones
+// DarwinInfo does not declare.
+func GetInfo(ctx context.Context, name string) (map[string]interface{}, error) {
+	output, err := darwinInfoOutput(ctx, name)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse diskutil output: %s", err)
+		return nil, err
 	}
-	for i, key := range data.Keys {
-		d[key] = data.Values[i]
+	d := make(map[string]interface{})
+	if _, err := plist.Unmarshal(output, &d); err != nil {
+		return nil, parseError("get info for "+name, output, err)
 	}
 	return d, nil
 }
 
-func DarwinGetPartitions() *orderedmap.OrderedMap[string, Disk] {
-	disks, err := darwinGetDiskPartitions()
+type darwinBackend struct{}
+
+func (darwinBackend) ListDisks(ctx context.Context) (*orderedmap.OrderedMap[string, Disk], error) {
+	disks, err := darwinGetDiskPartitions(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := darwinAddImages(ctx, disks); err != nil {
+		return nil, err
+	}
+	if err := darwinAddEncryption(ctx, disks); err != nil {
+		return nil, err
+	}
+	addMountState(disks)
+	return disks, nil
+}
+
+func (darwinBackend) Info(ctx context.Context, id string) (map[string]interface{}, error) {
+	return GetInfo(ctx, id)
+}
+
+func (darwinBackend) Open(path string) error {
+	_, err := runCommand(context.Background(), "open "+path, exec.Command("open", path))
+	return err
+}
+
+func (darwinBackend) Mount(ctx context.Context, partition Partition, options MountOptions) (Partition, error) {
+	op := "mount " + partition.Device
+	args := []string{"mount"}
+	if options.ReadOnly {
+		args = append(args, "readOnly")
+	}
+	if options.Path != "" {
+		if err := validateMountFolder(options.Path); err != nil {
+			return partition, err
+		}
+		args = append(args, "-mountPoint", options.Path)
+	}
+	args = append(args, partition.Device)
+	if _, err := runCommand(ctx, op, elevate.Command("diskutil", args...)); err != nil {
+		return partition, err
+	}
+	info, err := DarwinGetInfo(ctx, partition.Device)
+	if err != nil {
+		return partition, err
+	}
+	partition.MountPoint = info.MountPoint
+	partition.ReadOnly = !info.WritableVolume
+	if partition.MountPoint == "" {
+		return partition, parseError(op, nil, fmt.Errorf("diskutil reported no mount point for %s", partition.ID))
+	}
+	return partition, nil
+}
+
+func (darwinBackend) Unmount(ctx context.Context, partition Partition) (Partition, error) {
+	if _, err := runCommand(ctx, "unmount "+partition.Device, exec.Command("diskutil", "unmount", partition.Device)); err != nil {
+		return partition, err
+	}
+	partition.MountPoint = ""
+	partition.ReadOnly = false
+	return partition, nil
+}
+
+func (darwinBackend) Unlock(ctx context.Context, partition Partition, passphrase string) error {
+	// unlockVolume mounts the volume once it is unlocked
+	cmd := exec.Command("diskutil", "apfs", "unlockVolume", partition.Device, "-stdinpassphrase")
+	cmd.Stdin = strings.NewReader(passphrase)
+	_, err := runCommand(ctx, "unlock "+partition.Device, cmd)
+	return err
+}
+
+func (darwinBackend) Lock(ctx context.Context, partition Partition) error {
+	_, err := runCommand(ctx, "lock "+partition.Device, exec.Command("diskutil", "apfs", "lockVolume", partition.Device))
+	return err
+}
+
+func (darwinBackend) Filesystems() []string {
+	return []string{FilesystemAPFS, FilesystemHFS, FilesystemExFAT, FilesystemFAT32}
+}
+
+func (darwinBackend) FormatDisk(ctx context.Context, disk Disk, options FormatOptions) error {
+	personality, label, err := darwinEraseArgs(options)
+	if err != nil {
+		return err
+	}
+	_, err = runCommand(ctx, "erase "+disk.Device, exec.Command("diskutil", "eraseDisk", personality, label, options.Scheme, disk.Device))
+	return err
+}
+
+func (darwinBackend) FormatPartition(ctx context.Context, partition Partition, options FormatOptions) error {
+	personality, label, err := darwinEraseArgs(options)
+	if err != nil {
+		return err
+	}
+	_, err = runCommand(ctx, "erase "+partition.Device, exec.Command("diskutil", "eraseVolume", personality, label, partition.Device))
+	return err
+}
+
+// Rename looks the filesystem up first, as the listing only knows it for APFS
+// volumes.
+func (darwinBackend) Rename(ctx context.Context, partition Partition, label string) error {
+	info, err := DarwinGetInfo(ctx, partition.Device)
 	if err != nil {
-		fmt.Println("Error:", err)
-		return nil
+		return err
 	}
-	return disks
+	if label, err = validateLabel(info.FilesystemType, label); err != nil {
+		return err
+	}
+	_, err = runCommand(ctx, "rename "+partition.Device, exec.Command("diskutil", "rename", partition.Device, label))
+	return err
+}
+
+func (darwinBackend) Verify(ctx context.Context, partition Partition, output func(line string)) error {
+	return streamCommand(ctx, "verify "+partition.Device, exec.Command("diskutil", "verifyVolume", partition.Device), output)
 }
 
-func DarwinOpenFolder(path string) {
-	cmd := exec.Command("open", path)
-	cmd.Run()
+// Repair leaves unmounting to diskutil, which mounts the volume again once it
+// is repaired.
+func (darwinBackend) Repair(ctx context.Context, partition Partition, output func(line string)) error {
+	return streamCommand(ctx, "repair "+partition.Device, exec.Command("diskutil", "repairVolume", partition.Device), output)
 }
 
-func DarwinMountPartition(partition Partition) (bool, Partition) {
-	cmd := elevate.Command("diskutil", "mount", partition.Device)
-	_, e := cmd.Output()
-	if e != nil {
-		return false, partition
+func (darwinBackend) PartitionTable(ctx context.Context, disk Disk) (PartitionTable, error) {
+	info, err := DarwinGetInfo(ctx, disk.Device)
+	if err != nil {
+		return PartitionTable{}, err
+	}
+	scheme := "GPT"
+	switch info.Content {
+	case "GUID_partition_scheme":
+	case "FDisk_partition_scheme":
+		scheme = "MBR"
+	default:
+		return PartitionTable{}, userErrorf("%s has no partition map Qartion can edit", disk.Name)
+	}
+	output, err := runCommand(ctx, "list partitions of "+disk.Device, exec.Command("diskutil", "list", "-plist", disk.Device))
+	if err != nil {
+		return PartitionTable{}, err
 	}
-	info, e := GetInfo(partition.ID)
-	if e != nil {
-		return false, partition
+	list, err := darwinParseList(output)
+	if err != nil {
+		return PartitionTable{}, err
+	}
+	var entries []TableEntry
+	for _, entry := range list.AllDisksAndPartitions {
+		if entry.DeviceIdentifier != disk.Device {
+			continue
+		}
+		for _, partition := range entry.Partitions {
+			partitionInfo, err := DarwinGetInfo(ctx, partition.DeviceIdentifier)
+			if err != nil {
+				return PartitionTable{}, err
+			}
+			_, number, _ := strings.Cut(strings.TrimPrefix(partition.DeviceIdentifier, disk.Device), "s")
+			filesystem := partitionInfo.FilesystemName
+			if filesystem == "" {
+				// APFS physical stores and the EFI partition
+				filesystem = partition.Content
+			}
+			n, _ := strconv.Atoi(number)
+			entries = append(entries, TableEntry{
+				Number:     n,
+				Name:       partition.VolumeName,
+				Filesystem: filesystem,
+				Offset:     partitionInfo.PartitionOffset,
+				Size:       partition.Size,
+			})
+		}
 	}
-	e = exec.Command("open", info["MountPoint"].(string)).Run()
-	partition.MountPoint = info["MountPoint"].(string)
-	return e == nil, partition
+	return newPartitionTable(scheme, info.Size, entries), nil
+}
+
+func (darwinBackend) CheckOperation(table PartitionTable, op Operation) error {
+	return darwinCheckOperation(table, op)
+}
+
+// ApplyPlan runs one diskutil command per operation. diskutil cannot place a
+// partition at an offset, so a new partition is added after the partition
+// before it and may start elsewhere than planned. diskutil also renumbers the
+// partitions as they come and go, so the table is read again before every
+// step, partitions are found by where they start, and where each new one
+// really starts is looked up once it exists.
+func (b darwinBackend) ApplyPlan(ctx context.Context, disk Disk, plan *Plan) error {
+	device := func(number int) string {
+		return fmt.Sprintf("%ss%d", disk.Device, number)
+	}
+	// offsets maps where the plan starts the partitions it creates to where
+	// diskutil started them
+	offsets := make(map[uint64]uint64)
+	actual := func(offset uint64) uint64 {
+		if moved, ok := offsets[offset]; ok {
+			return moved
+		}
+		return offset
+	}
+	for _, step := range plan.Steps() {
+		if err := darwinCheckOperation(step.Before, step.Operation); err != nil {
+			return err
+		}
+		table, err := b.PartitionTable(ctx, disk)
+		if err != nil {
+			return err
+		}
+		entry := step.Operation.Entry
+		// a partition is created after the one before it, which is the
+		// partition the command is given
+		offset := entry.Offset
+		if step.Operation.Kind == OperationCreate {
+			before, _ := step.Before.entryBefore(entry.Offset)
+			offset = before.Offset
+		}
+		current, found := table.entryAt(actual(offset))
+		if !found {
+			return fmt.Errorf("no partition of %s starts at byte %d", disk.Device, actual(offset))
+		}
+		var args []string
+		switch step.Operation.Kind {
+		case OperationDelete:
+			args = []string{"eraseVolume", "free", "none", device(current.Number)}
+		case OperationCreate:
+			personality, label, err := darwinEraseArgs(FormatOptions{Filesystem: entry.Filesystem, Label: entry.Name})
+			if err != nil {
+				return err
+			}
+			args = []string{"addPartition", device(current.Number), personality, label, fmt.Sprintf("%dB", entry.Size)}
+		case OperationResize:
+			args = []string{"resizeVolume", device(current.Number), fmt.Sprintf("%dB", entry.Size)}
+			if entry.Filesystem == "Apple_APFS" {
+				args = []string{"apfs", "resizeContainer", device(current.Number), fmt.Sprintf("%dB", entry.Size)}
+			}
+		}
+		if _, err := runCommand(ctx, "edit partitions of "+disk.Device, exec.Command("diskutil", args...)); err != nil {
+			return err
+		}
+		if step.Operation.Kind == OperationCreate {
+			table, err := b.PartitionTable(ctx, disk)
+			if err != nil {
+				return err
+			}
+			added, ok := table.entryAfter(current.End())
+			if !ok {
+				return fmt.Errorf("diskutil added no partition after %s", device(current.Number))
+			}
+			offsets[entry.Offset] = added.Offset
+		}
+	}
+	return nil
+}
+
+func (darwinBackend) AttachImage(ctx context.Context, path string) error {
+	_, err := runCommand(ctx, "attach "+path, exec.Command("hdiutil", "attach", path))
+	return err
+}
+
+func (darwinBackend) DetachImage(ctx context.Context, disk Disk) error {
+	_, err := runCommand(ctx, "detach "+disk.Image, exec.Command("hdiutil", "detach", disk.Device))
+	return err
+}
+
+func (darwinBackend) Watcher() Watcher {
+	return newCommandWatcher(darwinParseActivity, "diskutil", "activity")
+}
+
+func (darwinBackend) Eject(ctx context.Context, disk Disk) error {
+	_, err := runCommand(ctx, "eject "+disk.Device, exec.Command("diskutil", "eject", disk.Device))
+	return err
 }
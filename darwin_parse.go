@@ -0,0 +1,298 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/oq-x/go-plist"
+	orderedmap "github.com/wk8/go-ordered-map/v2"
+)
+
+// darwinList mirrors the output of `diskutil list -plist`. Only the keys
+// Qartion uses are declared, so keys Apple adds or reorders are ignored.
+type darwinList struct {
+	AllDisksAndPartitions []darwinListDisk `plist:"AllDisksAndPartitions"`
+}
+
+type darwinListDisk struct {
+	DeviceIdentifier   string                `plist:"DeviceIdentifier"`
+	Content            string                `plist:"Content"`
+	Size               uint64                `plist:"Size"`
+	OSInternal         bool                  `plist:"OSInternal"`
+	VolumeName         string                `plist:"VolumeName"`
+	VolumeUUID         string                `plist:"VolumeUUID"`
+	MountPoint         string                `plist:"MountPoint"`
+	Partitions         []darwinListPartition `plist:"Partitions"`
+	APFSVolumes        []darwinListVolume    `plist:"APFSVolumes"`
+	APFSPhysicalStores []darwinPhysicalStore `plist:"APFSPhysicalStores"`
+}
+
+type darwinListPartition struct {
+	DeviceIdentifier string `plist:"DeviceIdentifier"`
+	Content          string `plist:"Content"`
+	DiskUUID         string `plist:"DiskUUID"`
+	Size             uint64 `plist:"Size"`
+	VolumeName       string `plist:"VolumeName"`
+	VolumeUUID       string `plist:"VolumeUUID"`
+	MountPoint       string `plist:"MountPoint"`
+}
+
+type darwinListVolume struct {
+	DeviceIdentifier string                  `plist:"DeviceIdentifier"`
+	DiskUUID         string                  `plist:"DiskUUID"`
+	Size             uint64                  `plist:"Size"`
+	CapacityInUse    uint64                  `plist:"CapacityInUse"`
+	VolumeName       string                  `plist:"VolumeName"`
+	VolumeUUID       string                  `plist:"VolumeUUID"`
+	MountPoint       string                  `plist:"MountPoint"`
+	OSInternal       bool                    `plist:"OSInternal"`
+	MountedSnapshots []darwinMountedSnapshot `plist:"MountedSnapshots"`
+}
+
+type darwinMountedSnapshot struct {
+	SnapshotMountPoint string `plist:"SnapshotMountPoint"`
+}
+
+type darwinPhysicalStore struct {
+	DeviceIdentifier string `plist:"DeviceIdentifier"`
+}
+
+// DarwinInfo mirrors the output of `diskutil info -plist`.
+type DarwinInfo struct {
+	DeviceIdentifier       string `plist:"DeviceIdentifier"`
+	DeviceNode             string `plist:"DeviceNode"`
+	ParentWholeDisk        string `plist:"ParentWholeDisk"`
+	WholeDisk              bool   `plist:"WholeDisk"`
+	MediaName              string `plist:"MediaName"`
+	IORegistryEntryName    string `plist:"IORegistryEntryName"`
+	Content                string `plist:"Content"`
+	VolumeName             string `plist:"VolumeName"`
+	VolumeUUID             string `plist:"VolumeUUID"`
+	DiskUUID               string `plist:"DiskUUID"`
+	MountPoint             string `plist:"MountPoint"`
+	FilesystemType         string `plist:"FilesystemType"`
+	FilesystemName         string `plist:"FilesystemName"`
+	Size                   uint64 `plist:"Size"`
+	FreeSpace              uint64 `plist:"FreeSpace"`
+	APFSContainerFree      uint64 `plist:"APFSContainerFree"`
+	APFSContainerReference string `plist:"APFSContainerReference"`
+	DeviceBlockSize        uint64 `plist:"DeviceBlockSize"`
+	PartitionOffset        uint64 `plist:"PartitionMapPartitionOffset"`
+	BusProtocol            string `plist:"BusProtocol"`
+	SMARTStatus            string `plist:"SMARTStatus"`
+	Internal               bool   `plist:"Internal"`
+	RemovableMedia         bool   `plist:"RemovableMedia"`
+	Ejectable              bool   `plist:"Ejectable"`
+	WritableVolume         bool   `plist:"WritableVolume"`
+	Encryption             bool   `plist:"Encryption"`
+	FileVault              bool   `plist:"FileVault"`
+}
+
+func darwinParseList(output []byte) (darwinList, error) {
+	var list darwinList
+	if _, err := plist.Unmarshal(output, &list); err != nil {
+		return list, parseError("list disks", output, err)
+	}
+	return list, nil
+}
+
+func darwinParseInfo(name string, output []byte) (DarwinInfo, error) {
+	var info DarwinInfo
+	if _, err := plist.Unmarshal(output, &info); err != nil {
+		return info, parseError("get info for "+name, output, err)
+	}
+	return info, nil
+}
+
+// darwinBuildDisks turns the parsed diskutil list into disks. APFS containers
+// are synthesized disks in diskutil, so they are attached as a partition of
+// the physical disk holding the container's physical store instead, with the
+// container's volumes nested below. mediaName looks up the display name of a
+// whole disk.
+func darwinBuildDisks(list darwinList, mediaName func(id string) (string, error)) (*orderedmap.OrderedMap[string, Disk], error) {
+	disks := orderedmap.New[string, Disk]()
+	stores := make(map[string]string)
+	for _, entry := range list.AllDisksAndPartitions {
+		if darwinIsContainer(entry) {
+			continue
+		}
+		name, err := mediaName(entry.DeviceIdentifier)
+		if err != nil {
+			return nil, err
+		}
+		partitions := orderedmap.New[string, Partition]()
+		if len(entry.Partitions) == 0 && (entry.VolumeName != "" || entry.MountPoint != "") {
+			// filesystem written directly on the disk, without a partition map
+			partitions.Set(entry.DeviceIdentifier, Partition{
+				ID:         entry.DeviceIdentifier,
+				Type:       entry.Content,
+				Name:       darwinVolumeName(entry.VolumeName, entry.MountPoint),
+				Size:       entry.Size,
+				Device:     entry.DeviceIdentifier,
+				UUID:       entry.VolumeUUID,
+				MountPoint: entry.MountPoint,
+			})
+		}
+		for _, par := range entry.Partitions {
+			stores[par.DeviceIdentifier] = entry.DeviceIdentifier
+			if par.VolumeName == "" && par.VolumeUUID == "" && par.MountPoint == "" {
+				// APFS physical stores, Microsoft Reserved and other
+				// partitions without a filesystem of their own
+				continue
+			}
+			id := par.DiskUUID
+			if id == "" {
+				id = par.DeviceIdentifier
+			}
+			partitions.Set(id, Partition{
+				ID:         id,
+				Type:       par.Content,
+				Name:       darwinVolumeName(par.VolumeName, par.MountPoint),
+				Size:       par.Size,
+				Device:     par.DeviceIdentifier,
+				UUID:       par.VolumeUUID,
+				MountPoint: par.MountPoint,
+			})
+		}
+		disks.Set(entry.DeviceIdentifier, Disk{
+			ID:         entry.DeviceIdentifier,
+			Name:       name,
+			Size:       entry.Size,
+			Type:       entry.Content,
+			Device:     entry.DeviceIdentifier,
+			Partitions: partitions,
+		})
+	}
+
+	for _, entry := range list.AllDisksAndPartitions {
+		if !darwinIsContainer(entry) {
+			continue
+		}
+		var disk Disk
+		var ok bool
+		for _, store := range entry.APFSPhysicalStores {
+			if disk, ok = disks.Get(stores[store.DeviceIdentifier]); ok {
+				break
+			}
+		}
+		if !ok {
+			continue
+		}
+		// the volumes of a container share its free space, which is what
+		// diskutil info reports as APFSContainerFree
+		var inUse, containerFree uint64
+		for _, volume := range entry.APFSVolumes {
+			inUse += volume.CapacityInUse
+		}
+		if entry.Size > inUse {
+			containerFree = entry.Size - inUse
+		}
+		volumes := orderedmap.New[string, Partition]()
+		for _, volume := range entry.APFSVolumes {
+			if volume.OSInternal {
+				// Preboot, Recovery, VM and friends
+				continue
+			}
+			mountPoint := volume.MountPoint
+			if mountPoint == "" && len(volume.MountedSnapshots) > 0 {
+				// the sealed system volume is only mounted through a snapshot
+				mountPoint = volume.MountedSnapshots[0].SnapshotMountPoint
+			}
+			id := volume.DiskUUID
+			if id == "" {
+				id = volume.DeviceIdentifier
+			}
+			volumes.Set(id, Partition{
+				ID:         id,
+				Type:       "Apple_APFS",
+				Name:       darwinVolumeName(volume.VolumeName, mountPoint),
+				Size:       volume.Size,
+				Device:     volume.DeviceIdentifier,
+				UUID:       volume.VolumeUUID,
+				Filesystem: "apfs",
+				MountPoint: mountPoint,
+				Free:       containerFree,
+				Used:       volume.CapacityInUse,
+			})
+		}
+		disk.Partitions.Set(entry.DeviceIdentifier, Partition{
+			ID:         entry.DeviceIdentifier,
+			Type:       "Apple_APFS_Container",
+			Name:       "APFS Container " + entry.DeviceIdentifier,
+			Size:       entry.Size,
+			Device:     entry.DeviceIdentifier,
+			Filesystem: "apfs",
+			Partitions: volumes,
+		})
+	}
+	return disks, nil
+}
+
+func darwinIsContainer(entry darwinListDisk) bool {
+	return len(entry.APFSPhysicalStores) > 0 || len(entry.APFSVolumes) > 0
+}
+
+func darwinVolumeName(name string, mountPoint string) string {
+	if name == "" && mountPoint != "" && mountPoint != "/" {
+		return filepath.Base(mountPoint)
+	}
+	return name
+}
+
+// darwinPersonalities maps filesystems to the names diskutil erases with.
+var darwinPersonalities = map[string]string{
+	FilesystemAPFS:  "APFS",
+	FilesystemHFS:   "JHFS+",
+	FilesystemExFAT: "ExFAT",
+	FilesystemFAT32: "FAT32",
+}
+
+// darwinCheckOperation refuses to create a partition anywhere but right
+// after another one, as `diskutil addPartition` has no offset to give and
+// adds the partition straight after the one it is handed.
+func darwinCheckOperation(table PartitionTable, op Operation) error {
+	if op.Kind != OperationCreate {
+		return nil
+	}
+	if before, ok := table.entryBefore(op.Entry.Offset); !ok || alignUp(before.End()) != op.Entry.Offset {
+		return userErrorf("diskutil can only add a partition right after another one")
+	}
+	return nil
+}
+
+// darwinEraseArgs returns the personality and name diskutil erases with.
+// diskutil insists on a name, and FAT names are upper case.
+func darwinEraseArgs(options FormatOptions) (string, string, error) {
+	personality, ok := darwinPersonalities[options.Filesystem]
+	if !ok {
+		return "", "", fmt.Errorf("cannot create %s on macOS", options.Filesystem)
+	}
+	label := options.Label
+	if label == "" {
+		label = "Untitled"
+	}
+	if options.Filesystem == FilesystemFAT32 {
+		label = strings.ToUpper(label)
+	}
+	return personality, label, nil
+}
+
+// darwinParseActivity parses a line of `diskutil activity`, such as
+// "***DiskAppeared ('disk4s1', DAVolumePath = '<null>', ...) Time=...".
+func darwinParseActivity(line string) (DeviceEvent, bool) {
+	name, rest, ok := strings.Cut(strings.TrimPrefix(line, "***"), " ('")
+	if !ok || !strings.HasPrefix(line, "***") {
+		return DeviceEvent{}, false
+	}
+	device, _, _ := strings.Cut(rest, "'")
+	switch name {
+	case "DiskAppeared":
+		return DeviceEvent{Kind: DeviceAdded, Device: device}, true
+	case "DiskDisappeared":
+		return DeviceEvent{Kind: DeviceRemoved, Device: device}, true
+	case "DiskDescriptionChanged":
+		return DeviceEvent{Kind: DeviceChanged, Device: device}, true
+	}
+	return DeviceEvent{}, false
+}
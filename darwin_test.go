@@ -0,0 +1,195 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+// darwinMediaNames stands in for diskutil info in darwinBuildDisks.
+func darwinMediaNames(names map[string]string) func(id string) (string, error) {
+	return func(id string) (string, error) {
+		return names[id], nil
+	}
+}
+
+func TestDarwinBuildDisks(t *testing.T) {
+	names := map[string]string{
+		"disk0": "APPLE SSD AP0512Q",
+		"disk4": "My Book 25EE",
+		"disk5": "Ultra Fit",
+		"disk6": "External HFS",
+	}
+	tests := []struct {
+		fixture string
+		want    []string
+	}{
+		// Intel: the Boot Camp partition sits next to the APFS store, and the
+		// sealed system volume is only mounted through its snapshot
+		{"diskutil-list-ventura.plist", []string{
+			`disk0 "APPLE SSD AP0512Q" 500277790720 GUID_partition_scheme`,
+			`  B2D1C4E8-2F3A-4B5C-8D9E-0A1B2C3D4E5F "EFI" 209715200  dev=disk0s1`,
+			`  C7D8E9F0-1A2B-4C3D-9E4F-5A6B7C8D9E0F "BOOTCAMP" 100124958720  dev=disk0s3 at=/Volumes/BOOTCAMP [mounted]`,
+			`  disk1 "APFS Container disk1" 399943016448 apfs dev=disk1 [container]`,
+			`    0A1B2C3D-4E5F-4A6B-8C7D-9E0F1A2B3C4D "Macintosh HD - Data" 399943016448 apfs dev=disk1s1 at=/System/Volumes/Data [mounted]`,
+			`    7B8C9D0E-1F2A-4B3C-8D4E-5F6A7B8C9D0E "Macintosh HD" 399943016448 apfs dev=disk1s5 at=/ [mounted]`,
+		}},
+		// Apple silicon: three containers, one of which has only internal
+		// volumes, and an external HFS+ disk
+		{"diskutil-list-sonoma.plist", []string{
+			`disk0 "APPLE SSD AP0512Q" 500277790720 GUID_partition_scheme`,
+			`  disk1 "APFS Container disk1" 524288000 apfs dev=disk1`,
+			`  disk3 "APFS Container disk3" 494384795648 apfs dev=disk3 [container]`,
+			`    B8C9D0E1-F2A3-4B4C-9D6E-7F8A9B0C1D2E "Macintosh HD" 494384795648 apfs dev=disk3s1 at=/ [mounted]`,
+			`    F2A3B4C5-D6E7-4F8A-9B0C-1D2E3F4A5B6C "Data" 494384795648 apfs dev=disk3s5 at=/System/Volumes/Data [mounted]`,
+			`disk4 "My Book 25EE" 2000398934016 GUID_partition_scheme`,
+			`  E3F4A5B6-C7D8-4E9F-8A0B-1C2D3E4F5A6B "EFI" 209715200  dev=disk4s1`,
+			`  F4A5B6C7-D8E9-4F0A-9B1C-2D3E4F5A6B7C "Time Machine" 2000054960128  dev=disk4s2 at=/Volumes/Time Machine [mounted]`,
+		}},
+		// an MBR stick, whose partitions have no DiskUUID, and HFS+ written
+		// on a whole disk
+		{"diskutil-list-sequoia.plist", []string{
+			`disk0 "APPLE SSD AP0512Q" 1000555581440 GUID_partition_scheme`,
+			`  disk3 "APFS Container disk3" 994662584320 apfs dev=disk3 [container]`,
+			`    B7C8D9E0-F1A2-4B3C-9D4E-5F6A7B8C9D0E "Macintosh HD" 994662584320 apfs dev=disk3s1 at=/ [mounted]`,
+			`    D9E0F1A2-B3C4-4D5E-9F6A-7B8C9D0E1F2A "Data" 994662584320 apfs dev=disk3s5 at=/System/Volumes/Data [mounted]`,
+			`disk5 "Ultra Fit" 31914983424 FDisk_partition_scheme`,
+			`  disk5s1 "USB" 31913934848  dev=disk5s1 at=/Volumes/USB [mounted]`,
+			`disk6 "External HFS" 320072933376 Apple_HFS`,
+			`  disk6 "Archive" 320072933376  dev=disk6 at=/Volumes/Archive [mounted]`,
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.fixture, func(t *testing.T) {
+			list, err := darwinParseList(readFixture(t, test.fixture))
+			if err != nil {
+				t.Fatal(err)
+			}
+			disks, err := darwinBuildDisks(list, darwinMediaNames(names))
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkLines(t, diskLines(disks), test.want)
+		})
+	}
+}
+
+func TestDarwinBuildDisksContainerSpace(t *testing.T) {
+	list, err := darwinParseList(readFixture(t, "diskutil-list-ventura.plist"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	disks, err := darwinBuildDisks(list, darwinMediaNames(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	disk, _ := disks.Get("disk0")
+	data, ok := findNestedPartition(disk.Partitions, "0A1B2C3D-4E5F-4A6B-8C7D-9E0F1A2B3C4D")
+	if !ok {
+		t.Fatal("no Data volume")
+	}
+	// the container's size less what all of its volumes use, internal ones
+	// included
+	if data.Free != 174339563520 || data.Used != 212511453184 {
+		t.Errorf("Data volume free %d, used %d", data.Free, data.Used)
+	}
+}
+
+func TestDarwinBuildDisksMediaNameError(t *testing.T) {
+	list, err := darwinParseList(readFixture(t, "diskutil-list-sonoma.plist"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	failure := errors.New("diskutil info failed")
+	_, err = darwinBuildDisks(list, func(string) (string, error) {
+		return "", failure
+	})
+	if !errors.Is(err, failure) {
+		t.Errorf("darwinBuildDisks = %v, want %v", err, failure)
+	}
+}
+
+func TestDarwinParseInfo(t *testing.T) {
+	data, err := darwinParseInfo("disk3s5", readFixture(t, "diskutil-info-sonoma-data.plist"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data.FilesystemType != "apfs" || data.MountPoint != "/System/Volumes/Data" || !data.WritableVolume ||
+		data.APFSContainerFree != 193412464640 || data.ParentWholeDisk != "disk3" || !data.FileVault {
+		t.Errorf("unexpected info for the Data volume: %+v", data)
+	}
+	hfs, err := darwinParseInfo("disk4s2", readFixture(t, "diskutil-info-external-hfs.plist"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hfs.FilesystemType != "hfs" || hfs.WritableVolume || hfs.PartitionOffset != 209735680 || hfs.BusProtocol != "USB" {
+		t.Errorf("unexpected info for the HFS+ volume: %+v", hfs)
+	}
+	if _, err := darwinParseInfo("disk9", []byte("Could not find disk: disk9")); KindOf(err) != KindParseFailure {
+		t.Errorf("darwinParseInfo of an error message = %v, want a parse failure", err)
+	}
+}
+
+func TestDarwinParseActivity(t *testing.T) {
+	tests := []struct {
+		line string
+		want DeviceEvent
+		ok   bool
+	}{
+		{"***DiskAppeared ('disk4', DAVolumePath = '<null>', DAVolumeKind = '<null>', DAVolumeName = '<null>') Time=20241012-14:03:51.1180", DeviceEvent{DeviceAdded, "disk4"}, true},
+		{"***DiskAppeared ('disk4s1', DAVolumePath = '<null>', DAVolumeKind = 'msdos', DAVolumeName = 'USB') Time=20241012-14:03:51.1694", DeviceEvent{DeviceAdded, "disk4s1"}, true},
+		{"***DiskDescriptionChanged ('disk4s1', DAVolumePath = 'file:///Volumes/USB/') Time=20241012-14:03:51.4460", DeviceEvent{DeviceChanged, "disk4s1"}, true},
+		{"***DiskDisappeared ('disk4s1', DAVolumePath = '<null>', DAVolumeKind = 'msdos', DAVolumeName = 'USB') Time=20241012-14:05:10.0032", DeviceEvent{DeviceRemoved, "disk4s1"}, true},
+		{"***DiskUnmountApproval ('disk4s1', DAVolumePath = 'file:///Volumes/USB/', DAVolumeKind = 'msdos', DAVolumeName = 'USB', Comment = 'Approving') Time=20241012-14:05:09.8817", DeviceEvent{}, false},
+		{"***DAIdle (no DA daemon activity) Time=20241012-14:03:52.0021", DeviceEvent{}, false},
+		{"***Begin monitoring DiskArbitration activity", DeviceEvent{}, false},
+		{"DiskAppeared ('disk4', DAVolumePath = '<null>')", DeviceEvent{}, false},
+	}
+	for _, test := range tests {
+		if got, ok := darwinParseActivity(test.line); got != test.want || ok != test.ok {
+			t.Errorf("darwinParseActivity(%q) = %v, %t, want %v, %t", test.line, got, ok, test.want, test.ok)
+		}
+	}
+}
+
+func TestDarwinCheckOperation(t *testing.T) {
+	const gib = 1 << 30
+	table := newPartitionTable("GPT", 64*gib, []TableEntry{
+		// diskutil starts the EFI partition at sector 40
+		{Number: 1, Name: "EFI", Filesystem: "EFI", Offset: 20480, Size: 200 << 20},
+		{Number: 2, Name: "Data", Filesystem: "Apple_HFS", Offset: 8 * gib, Size: 8 * gib},
+	})
+	tests := []struct {
+		name   string
+		offset uint64
+		ok     bool
+	}{
+		// rounded up to where diskutil puts a partition after the EFI one
+		{"after a partition", 20480 + 200<<20, true},
+		{"after the last partition", 16 * gib, true},
+		{"in the middle of the free space", 4 * gib, false},
+	}
+	for _, test := range tests {
+		plan := NewPlan(table)
+		plan.Check = darwinCheckOperation
+		err := plan.Create(test.offset, 1*gib, FilesystemExFAT, "STICK")
+		if (err == nil) != test.ok {
+			t.Errorf("%s: Create = %v, want ok %t", test.name, err, test.ok)
+		}
+	}
+
+	// nothing comes before the first partition to add one after
+	plan := NewPlan(newPartitionTable("GPT", 64*gib, []TableEntry{
+		{Number: 1, Name: "Data", Filesystem: "Apple_HFS", Offset: 8 * gib, Size: 8 * gib},
+	}))
+	plan.Check = darwinCheckOperation
+	if err := plan.Create(1<<20, 1*gib, FilesystemExFAT, "STICK"); err == nil {
+		t.Error("created a partition at the start of the disk")
+	}
+	// resizing and deleting are up to diskutil
+	if err := plan.Resize(1, 4*gib); err != nil {
+		t.Error(err)
+	}
+	if err := plan.Delete(1); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/therecipe/qt/widgets"
+)
+
+// describeOperation names an operation in the list of pending changes.
+func describeOperation(op Operation) string {
+	name := op.Entry.Name
+	if name == "" {
+		name = fmt.Sprintf("#%d", op.Entry.Number)
+	}
+	switch op.Kind {
+	case OperationCreate:
+		return trf("Create %s (%s, %s)", name, op.Entry.Filesystem, formatSize(op.Entry.Size))
+	case OperationDelete:
+		return trf("Delete %s", name)
+	}
+	return trf("Resize %s to %s", name, formatSize(op.Entry.Size))
+}
+
+// editPartitions reads the partition table of the card's disk in the
+// background and opens the partition editor on it.
+func (card *diskCard) editPartitions() {
+	disk := card.disk
+	runTask(trf("Reading partitions of %s…", disk.Name), listTimeout, func(ctx context.Context) func() {
+		table, err := backend.PartitionTable(ctx, disk)
+		return func() {
+			if err != nil {
+				card.showError(err)
+				return
+			}
+			if plan, ok := showPartitionEditor(disk, table); ok {
+				card.runUninterrupted(trf("Editing partitions of %s…", disk.Name), func(ctx context.Context) error {
+					return backend.ApplyPlan(ctx, disk, plan)
+				})
+			}
+		}
+	})
+}
+
+// showPartitionEditor lets the user queue changes to table and returns them
+// once they are confirmed. The boot disk is only shown, never changed.
+func showPartitionEditor(disk Disk, table PartitionTable) (*Plan, bool) {
+	var (
+		plan          = NewPlan(table)
+		readOnly      = isBootDisk(disk)
+		dialog        = widgets.NewQDialog(window, 0)
+		layout        = widgets.NewQVBoxLayout2(dialog)
+		tree          = widgets.NewQTreeWidget(nil)
+		actions       = widgets.NewQHBoxLayout()
+		createButton  = widgets.NewQPushButton2(tr("Create…"), nil)
+		deleteButton  = widgets.NewQPushButton2(tr("Delete"), nil)
+		resizeButton  = widgets.NewQPushButton2(tr("Resize…"), nil)
+		undoButton    = widgets.NewQPushButton2(tr("Undo"), nil)
+		pendingLabel  = widgets.NewQLabel2(tr("Pending changes:"), nil, 0)
+		pending       = widgets.NewQListWidget(nil)
+		buttons       = widgets.NewQDialogButtonBox3(widgets.QDialogButtonBox__Apply|widgets.QDialogButtonBox__Cancel, nil)
+		applyButton   = buttons.Button(widgets.QDialogButtonBox__Apply)
+		regions       []Region
+		currentRegion = func() (Region, bool) {
+			index := tree.IndexOfTopLevelItem(tree.CurrentItem())
+			if index < 0 || index >= len(regions) {
+				return Region{}, false
+			}
+			return regions[index], true
+		}
+	)
+	// operations the backend cannot carry out are refused right away rather
+	// than failing halfway through Apply
+	plan.Check = backend.CheckOperation
+	dialog.SetWindowTitle(trf("Partitions of %s", disk.Name))
+	dialog.Resize2(640, 480)
+	tree.SetHeaderLabels([]string{tr("Partition"), tr("Filesystem"), tr("Start"), tr("Size")})
+	tree.SetRootIsDecorated(false)
+	actions.AddWidget(createButton, 0, 0)
+	actions.AddWidget(deleteButton, 0, 0)
+	actions.AddWidget(resizeButton, 0, 0)
+	actions.AddStretch(1)
+	actions.AddWidget(undoButton, 0, 0)
+	layout.AddWidget(tree, 1, 0)
+	layout.AddLayout(actions, 0)
+	layout.AddWidget(pendingLabel, 0, 0)
+	layout.AddWidget(pending, 0, 0)
+	if readOnly {
+		layout.AddWidget(widgets.NewQLabel2(tr("The system runs from this disk, so its partitions cannot be changed."), nil, 0), 0, 0)
+	}
+	layout.AddWidget(buttons, 0, 0)
+
+	updateButtons := func() {
+		region, ok := currentRegion()
+		createButton.SetEnabled(!readOnly && ok && region.Entry == nil)
+		deleteButton.SetEnabled(!readOnly && ok && region.Entry != nil)
+		resizeButton.SetEnabled(!readOnly && ok && region.Entry != nil)
+		undoButton.SetEnabled(len(plan.Operations) > 0)
+		applyButton.SetEnabled(len(plan.Operations) > 0)
+	}
+	refresh := func() {
+		tree.Clear()
+		regions = plan.Table().Regions()
+		for _, region := range regions {
+			name, filesystem := tr("Unallocated"), ""
+			if region.Entry != nil {
+				name = fmt.Sprintf("#%d %s", region.Entry.Number, region.Entry.Name)
+				filesystem = region.Entry.Filesystem
+			}
+			widgets.NewQTreeWidgetItem4(tree, []string{name, filesystem, formatSize(region.Offset), formatSize(region.Size)}, 0)
+		}
+		pending.Clear()
+		for _, op := range plan.Operations {
+			pending.AddItem(describeOperation(op))
+		}
+		updateButtons()
+	}
+	change := func(err error) {
+		if err != nil {
+			widgets.QMessageBox_Warning(dialog, dialog.WindowTitle(), errorText(err), widgets.QMessageBox__Ok, widgets.QMessageBox__Ok)
+		}
+		refresh()
+	}
+
+	tree.ConnectItemSelectionChanged(updateButtons)
+	createButton.ConnectClicked(func(bool) {
+		region, ok := currentRegion()
+		if !ok {
+			return
+		}
+		if size, filesystem, label, ok := askNewPartition(dialog, region.Size); ok {
+			change(plan.Create(region.Offset, size, filesystem, label))
+		}
+	})
+	deleteButton.ConnectClicked(func(bool) {
+		if region, ok := currentRegion(); ok && region.Entry != nil {
+			change(plan.Delete(region.Entry.Number))
+		}
+	})
+	resizeButton.ConnectClicked(func(bool) {
+		region, ok := currentRegion()
+		if !ok || region.Entry == nil {
+			return
+		}
+		maxSize := plan.Table().MaxSize(region.Entry.Number)
+		if size, ok := askSize(dialog, tr("Resize Partition"), region.Entry.Size, maxSize); ok {
+			change(plan.Resize(region.Entry.Number, size))
+		}
+	})
+	undoButton.ConnectClicked(func(bool) {
+		plan.Undo()
+		refresh()
+	})
+	applyButton.ConnectClicked(func(bool) {
+		steps := make([]string, len(plan.Operations))
+		for i, op := range plan.Operations {
+			steps[i] = describeOperation(op)
+		}
+		text := trf("These changes will be made to %s and cannot be undone:", disk.Name) + "\n\n" + strings.Join(steps, "\n")
+		if widgets.QMessageBox_Question(dialog, tr("Apply Changes"), text, widgets.QMessageBox__Apply|widgets.QMessageBox__Cancel, widgets.QMessageBox__Cancel) == widgets.QMessageBox__Apply {
+			dialog.Accept()
+		}
+	})
+	buttons.ConnectRejected(func() {
+		dialog.Reject()
+	})
+
+	refresh()
+	accepted := dialog.Exec() == int(widgets.QDialog__Accepted)
+	dialog.DeleteLater()
+	return plan, accepted && len(plan.Operations) > 0
+}
+
+const mebibyte = 1 << 20
+
+// askSize asks for a size in MiB between 1 MiB and maxSize, starting at size.
+func askSize(parent widgets.QWidget_ITF, title string, size uint64, maxSize uint64) (uint64, bool) {
+	var (
+		dialog  = widgets.NewQDialog(parent, 0)
+		layout  = widgets.NewQFormLayout(dialog)
+		spin    = newSizeSpinBox(size, maxSize)
+		buttons = widgets.NewQDialogButtonBox3(widgets.QDialogButtonBox__Ok|widgets.QDialogButtonBox__Cancel, nil)
+	)
+	dialog.SetWindowTitle(title)
+	layout.AddRow3(tr("Size:"), spin)
+	layout.AddRow5(buttons)
+	buttons.ConnectAccepted(func() {
+		dialog.Accept()
+	})
+	buttons.ConnectRejected(func() {
+		dialog.Reject()
+	})
+	accepted := dialog.Exec() == int(widgets.QDialog__Accepted)
+	size = uint64(spin.Value()) * mebibyte
+	dialog.DeleteLater()
+	return size, accepted
+}
+
+// askNewPartition asks for the size, filesystem and label of a partition in
+// maxSize bytes of unallocated space.
+func askNewPartition(parent widgets.QWidget_ITF, maxSize uint64) (uint64, string, string, bool) {
+	var (
+		dialog        = widgets.NewQDialog(parent, 0)
+		layout        = widgets.NewQFormLayout(dialog)
+		spin          = newSizeSpinBox(maxSize, maxSize)
+		filesystemBox = widgets.NewQComboBox(nil)
+		labelEdit     = widgets.NewQLineEdit(nil)
+		buttons       = widgets.NewQDialogButtonBox3(widgets.QDialogButtonBox__Ok|widgets.QDialogButtonBox__Cancel, nil)
+	)
+	dialog.SetWindowTitle(tr("Create Partition"))
+	filesystemBox.AddItems(backend.Filesystems())
+	layout.AddRow3(tr("Size:"), spin)
+	layout.AddRow3(tr("Filesystem:"), filesystemBox)
+	layout.AddRow3(tr("Label:"), labelEdit)
+	layout.AddRow5(buttons)
+	var label string
+	buttons.ConnectAccepted(func() {
+		// an empty label creates the partition without one
+		label = strings.TrimSpace(labelEdit.Text())
+		if label != "" {
+			var err error
+			if label, err = validateLabel(filesystemBox.CurrentText(), label); err != nil {
+				widgets.QMessageBox_Warning(dialog, dialog.WindowTitle(), errorText(err), widgets.QMessageBox__Ok, widgets.QMessageBox__Ok)
+				return
+			}
+		}
+		dialog.Accept()
+	})
+	buttons.ConnectRejected(func() {
+		dialog.Reject()
+	})
+	accepted := dialog.Exec() == int(widgets.QDialog__Accepted)
+	size, filesystem := uint64(spin.Value())*mebibyte, filesystemBox.CurrentText()
+	dialog.DeleteLater()
+	return size, filesystem, label, accepted
+}
+
+func newSizeSpinBox(size uint64, maxSize uint64) *widgets.QSpinBox {
+	spin := widgets.NewQSpinBox(nil)
+	spin.SetRange(1, int(maxSize/mebibyte))
+	spin.SetSuffix(" MiB")
+	spin.SetValue(int(size / mebibyte))
+	return spin
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os/exec"
+	"strings"
+)
+
+type ErrorKind int
+
+const (
+	KindUnknown ErrorKind = iota
+	KindCommandNotFound
+	KindPermissionDenied
+	KindParseFailure
+	KindDeviceBusy
+	KindElevationCancelled
+	KindCancelled
+	KindTimedOut
+	KindWrongPassphrase
+	KindCorrupted
+)
+
+func (k ErrorKind) String() string {
+	switch k {
+	case KindCommandNotFound:
+		return "command not found"
+	case KindPermissionDenied:
+		return "permission denied"
+	case KindParseFailure:
+		return "could not understand the command output"
+	case KindDeviceBusy:
+		return "device is busy"
+	case KindElevationCancelled:
+		return "administrator authorization was cancelled"
+	case KindCancelled:
+		return "cancelled"
+	case KindTimedOut:
+		return "timed out"
+	case KindWrongPassphrase:
+		return "wrong password or recovery key"
+	case KindCorrupted:
+		return "the filesystem is damaged and needs to be repaired"
+	}
+	return "command failed"
+}
+
+// CommandError is returned by every backend function when an external tool
+// fails or prints something Qartion cannot parse. Stderr keeps the raw output
+// so the GUI can show it under "Details".
+type CommandError struct {
+	Kind    ErrorKind
+	Op      string
+	Command string
+	Stderr  string
+	Err     error
+}
+
+func (e *CommandError) Error() string {
+	if e.Kind != KindUnknown {
+		return fmt.Sprintf("%s: %s", e.Op, e.Kind)
+	}
+	if line, _, _ := strings.Cut(strings.TrimSpace(e.Stderr), "\n"); line != "" {
+		return fmt.Sprintf("%s: %s", e.Op, line)
+	}
+	return fmt.Sprintf("%s: %s", e.Op, e.Err)
+}
+
+func (e *CommandError) Unwrap() error {
+	return e.Err
+}
+
+// KindOf reports the ErrorKind of err, or KindUnknown if err is not a
+// *CommandError.
+func KindOf(err error) ErrorKind {
+	var commandError *CommandError
+	if errors.As(err, &commandError) {
+		return commandError.Kind
+	}
+	return KindUnknown
+}
+
+// runCommand runs cmd and returns its standard output. A failure is turned
+// into a *CommandError classified from the error and from what the command
+// wrote to stderr. The command is killed when ctx is done, which also covers
+// commands built by elevate that cannot use exec.CommandContext.
+func runCommand(ctx context.Context, op string, cmd *exec.Cmd) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	if cmd.Stderr == nil {
+		cmd.Stderr = &stderr
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, &CommandError{
+			Kind:    classifyError(err, ""),
+			Op:      op,
+			Command: strings.Join(cmd.Args, " "),
+			Err:     err,
+		}
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+	select {
+	case err := <-done:
+		return commandResult(op, cmd, stdout.Bytes(), stderr.String(), err)
+	case <-ctx.Done():
+		// Wait is left running in the background, as it can block for as
+		// long as a child of the killed process keeps the pipes open
+		cmd.Process.Kill()
+		kind := KindCancelled
+		if ctx.Err() == context.DeadlineExceeded {
+			kind = KindTimedOut
+		}
+		return nil, &CommandError{
+			Kind:    kind,
+			Op:      op,
+			Command: strings.Join(cmd.Args, " "),
+			Err:     ctx.Err(),
+		}
+	}
+}
+
+// streamCommand runs cmd like runCommand, but hands every line it writes to
+// stdout or stderr to output as soon as it is written, from another goroutine.
+// Progress lines ended by a carriage return count as lines too. A failure is
+// classified from all of the lines.
+func streamCommand(ctx context.Context, op string, cmd *exec.Cmd, output func(line string)) error {
+	reader, writer := io.Pipe()
+	cmd.Stdout, cmd.Stderr = writer, writer
+	if err := cmd.Start(); err != nil {
+		return &CommandError{
+			Kind:    classifyError(err, ""),
+			Op:      op,
+			Command: strings.Join(cmd.Args, " "),
+			Err:     err,
+		}
+	}
+	var text strings.Builder
+	scanned := make(chan struct{})
+	go func() {
+		scanner := bufio.NewScanner(reader)
+		scanner.Split(scanOutputLines)
+		for scanner.Scan() {
+			if line := strings.TrimRight(scanner.Text(), " "); line != "" {
+				text.WriteString(line + "\n")
+				output(line)
+			}
+		}
+		// keep draining after an overlong line, so the command never blocks
+		io.Copy(io.Discard, reader)
+		close(scanned)
+	}()
+	done := make(chan error, 1)
+	go func() {
+		err := cmd.Wait()
+		writer.Close()
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		<-scanned
+		_, err = commandResult(op, cmd, nil, text.String(), err)
+		return err
+	case <-ctx.Done():
+		cmd.Process.Kill()
+		kind := KindCancelled
+		if ctx.Err() == context.DeadlineExceeded {
+			kind = KindTimedOut
+		}
+		return &CommandError{
+			Kind:    kind,
+			Op:      op,
+			Command: strings.Join(cmd.Args, " "),
+			Err:     ctx.Err(),
+		}
+	}
+}
+
+// exitCode returns the exit status of the command that failed with err, or -1
+// if it did not exit on its own.
+func exitCode(err error) int {
+	var exitError *exec.ExitError
+	if errors.As(err, &exitError) {
+		return exitError.ExitCode()
+	}
+	return -1
+}
+
+// scanOutputLines is bufio.ScanLines, but also splits at carriage returns.
+func scanOutputLines(data []byte, atEOF bool) (int, []byte, error) {
+	if i := bytes.IndexAny(data, "\r\n"); i >= 0 {
+		return i + 1, data[:i], nil
+	}
+	if atEOF && len(data) > 0 {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
+
+func commandResult(op string, cmd *exec.Cmd, output []byte, stderr string, err error) ([]byte, error) {
+	if err != nil {
+		// some tools, diskutil included, report failures on stdout
+		text := strings.TrimSpace(stderr + "\n" + string(output))
+		return output, &CommandError{
+			Kind:    classifyError(err, text),
+			Op:      op,
+			Command: strings.Join(cmd.Args, " "),
+			Stderr:  text,
+			Err:     err,
+		}
+	}
+	return output, nil
+}
+
+func parseError(op string, output []byte, err error) error {
+	return &CommandError{
+		Kind:   KindParseFailure,
+		Op:     op,
+		Stderr: string(output),
+		Err:    err,
+	}
+}
+
+var errorPatterns = []struct {
+	kind     ErrorKind
+	patterns []string
+}{
+	{KindElevationCancelled, []string{"user canceled", "user cancelled", "canceled by the user", "cancelled by the user", "(-128)", "request dismissed", "error.cancelled"}},
+	{KindWrongPassphrase, []string{"passphrase incorrect", "incorrect passphrase", "no key available with this passphrase", "password is incorrect", "password is not correct", "0x80310027"}},
+	{KindCorrupted, []string{"corrupt", "needs to be repaired", "bad superblock", "structure needs cleaning", "unclean file system", "run chkdsk", "0x80070570"}},
+	{KindCommandNotFound, []string{"command not found", "is not recognized as"}},
+	{KindPermissionDenied, []string{"permission denied", "access is denied", "not authorized", "notauthorized", "not privileged", "operation not permitted", "must be run as root", "requires administrator"}},
+	{KindDeviceBusy, []string{"busy", "in use", "being used by another process", "dissented"}},
+}
+
+func classifyError(err error, stderr string) ErrorKind {
+	if errors.Is(err, exec.ErrNotFound) || errors.Is(err, fs.ErrNotExist) {
+		return KindCommandNotFound
+	}
+	if errors.Is(err, fs.ErrPermission) {
+		return KindPermissionDenied
+	}
+	text := strings.ToLower(stderr)
+	for _, entry := range errorPatterns {
+		for _, pattern := range entry.patterns {
+			if strings.Contains(text, pattern) {
+				return entry.kind
+			}
+		}
+	}
+	return KindUnknown
+}
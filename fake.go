@@ -0,0 +1,292 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	orderedmap "github.com/wk8/go-ordered-map/v2"
+)
+
+// FakeBackend keeps disks in memory and mounts partitions under /fake, which
+// is useful for tests and for running the UI on unsupported platforms.
+type FakeBackend struct {
+	mu     sync.Mutex
+	Disks  *orderedmap.OrderedMap[string, Disk]
+	Opened []string
+	Events *FakeWatcher
+}
+
+func NewFakeBackend(disks *orderedmap.OrderedMap[string, Disk]) *FakeBackend {
+	return &FakeBackend{Disks: disks, Events: NewFakeWatcher()}
+}
+
+func (f *FakeBackend) Watcher() Watcher {
+	return f.Events
+}
+
+// find looks the partition up on every disk, however deeply it is nested.
+func (f *FakeBackend) find(id string) (Disk, Partition, bool) {
+	for pair := f.Disks.Oldest(); pair != nil; pair = pair.Next() {
+		if partition, ok := findNestedPartition(pair.Value.Partitions, id); ok {
+			return pair.Value, partition, true
+		}
+	}
+	return Disk{}, Partition{}, false
+}
+
+func (f *FakeBackend) ListDisks(ctx context.Context) (*orderedmap.OrderedMap[string, Disk], error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	disks := orderedmap.New[string, Disk]()
+	for pair := f.Disks.Oldest(); pair != nil; pair = pair.Next() {
+		disk := pair.Value
+		disk.Partitions = copyPartitions(disk.Partitions)
+		disks.Set(pair.Key, disk)
+	}
+	return disks, nil
+}
+
+// copyPartitions copies the partitions and the ones nested in them, so that
+// the copy does not change when the fake does.
+func copyPartitions(partitions *orderedmap.OrderedMap[string, Partition]) *orderedmap.OrderedMap[string, Partition] {
+	if partitions == nil {
+		return nil
+	}
+	copied := orderedmap.New[string, Partition]()
+	for pair := partitions.Oldest(); pair != nil; pair = pair.Next() {
+		partition := pair.Value
+		partition.Partitions = copyPartitions(partition.Partitions)
+		copied.Set(pair.Key, partition)
+	}
+	return copied
+}
+
+func (f *FakeBackend) Mount(ctx context.Context, partition Partition, options MountOptions) (Partition, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	disk, stored, ok := f.find(partition.ID)
+	if !ok {
+		return partition, fmt.Errorf("no such partition: %s", partition.ID)
+	}
+	if stored.MountPoint == "" {
+		stored.MountPoint = "/fake/" + stored.ID
+		if options.Path != "" {
+			stored.MountPoint = options.Path
+		}
+		stored.ReadOnly = options.ReadOnly
+		setPartition(disk.Partitions, stored)
+	}
+	return stored, nil
+}
+
+func (f *FakeBackend) Unmount(ctx context.Context, partition Partition) (Partition, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	disk, stored, ok := f.find(partition.ID)
+	if !ok {
+		return partition, fmt.Errorf("no such partition: %s", partition.ID)
+	}
+	stored.MountPoint = ""
+	stored.ReadOnly = false
+	setPartition(disk.Partitions, stored)
+	return stored, nil
+}
+
+func (f *FakeBackend) Eject(ctx context.Context, disk Disk) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for pair := f.Disks.Oldest(); pair != nil; pair = pair.Next() {
+		if pair.Value.ID == disk.ID {
+			f.Disks.Delete(pair.Key)
+			return nil
+		}
+	}
+	return fmt.Errorf("no such disk: %s", disk.ID)
+}
+
+func (f *FakeBackend) Unlock(ctx context.Context, partition Partition, passphrase string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	disk, stored, ok := f.find(partition.ID)
+	if !ok || !stored.Locked {
+		return fmt.Errorf("no locked partition: %s", partition.ID)
+	}
+	if passphrase != "fake" {
+		return &CommandError{Kind: KindWrongPassphrase, Op: "unlock " + partition.ID}
+	}
+	stored.Locked = false
+	stored.MountPoint = "/fake/" + stored.ID
+	setPartition(disk.Partitions, stored)
+	return nil
+}
+
+func (f *FakeBackend) Lock(ctx context.Context, partition Partition) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	disk, stored, ok := f.find(partition.ID)
+	if !ok || !stored.Encrypted {
+		return fmt.Errorf("no encrypted partition: %s", partition.ID)
+	}
+	stored.Locked = true
+	stored.MountPoint = ""
+	setPartition(disk.Partitions, stored)
+	return nil
+}
+
+func (f *FakeBackend) Filesystems() []string {
+	return []string{FilesystemExFAT, FilesystemFAT32}
+}
+
+// FormatDisk replaces the partitions of the disk with a single one.
+func (f *FakeBackend) FormatDisk(ctx context.Context, disk Disk, options FormatOptions) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	stored, ok := f.Disks.Get(disk.ID)
+	if !ok {
+		return fmt.Errorf("no such disk: %s", disk.ID)
+	}
+	partitions := orderedmap.New[string, Partition]()
+	id := stored.ID + "s1"
+	partitions.Set(id, Partition{ID: id, Name: options.Label, Size: stored.Size, Device: id, Filesystem: options.Filesystem})
+	stored.Partitions = partitions
+	f.Disks.Set(stored.ID, stored)
+	return nil
+}
+
+func (f *FakeBackend) FormatPartition(ctx context.Context, partition Partition, options FormatOptions) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	disk, stored, ok := f.find(partition.ID)
+	if !ok {
+		return fmt.Errorf("no such partition: %s", partition.ID)
+	}
+	stored.Name = options.Label
+	stored.Filesystem = options.Filesystem
+	stored.MountPoint = ""
+	setPartition(disk.Partitions, stored)
+	return nil
+}
+
+func (f *FakeBackend) Rename(ctx context.Context, partition Partition, label string) error {
+	label, err := validateLabel(partition.Filesystem, label)
+	if err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	disk, stored, ok := f.find(partition.ID)
+	if !ok {
+		return fmt.Errorf("no such partition: %s", partition.ID)
+	}
+	stored.Name = label
+	setPartition(disk.Partitions, stored)
+	return nil
+}
+
+// Verify and Repair report a clean filesystem.
+func (f *FakeBackend) Verify(ctx context.Context, partition Partition, output func(line string)) error {
+	output("Checking " + partition.Device)
+	output("No problems found.")
+	return nil
+}
+
+func (f *FakeBackend) Repair(ctx context.Context, partition Partition, output func(line string)) error {
+	return f.Verify(ctx, partition, output)
+}
+
+// PartitionTable lays the partitions of the disk out one after the other.
+func (f *FakeBackend) PartitionTable(ctx context.Context, disk Disk) (PartitionTable, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	stored, ok := f.Disks.Get(disk.ID)
+	if !ok {
+		return PartitionTable{}, fmt.Errorf("no such disk: %s", disk.ID)
+	}
+	var entries []TableEntry
+	offset := uint64(partitionAlignment)
+	for pair := stored.Partitions.Oldest(); pair != nil; pair = pair.Next() {
+		entries = append(entries, TableEntry{
+			Number:     len(entries) + 1,
+			Name:       pair.Value.Name,
+			Filesystem: pair.Value.Filesystem,
+			Offset:     offset,
+			Size:       pair.Value.Size,
+		})
+		offset += alignUp(pair.Value.Size)
+	}
+	return newPartitionTable("GPT", stored.Size, entries), nil
+}
+
+func (f *FakeBackend) CheckOperation(table PartitionTable, op Operation) error {
+	return nil
+}
+
+// ApplyPlan replaces the partitions of the disk with the planned table.
+func (f *FakeBackend) ApplyPlan(ctx context.Context, disk Disk, plan *Plan) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	stored, ok := f.Disks.Get(disk.ID)
+	if !ok {
+		return fmt.Errorf("no such disk: %s", disk.ID)
+	}
+	partitions := orderedmap.New[string, Partition]()
+	for _, entry := range plan.Table().Entries {
+		id := fmt.Sprintf("%ss%d", stored.ID, entry.Number)
+		partitions.Set(id, Partition{ID: id, Name: entry.Name, Size: entry.Size, Device: id, Filesystem: entry.Filesystem})
+	}
+	stored.Partitions = partitions
+	f.Disks.Set(stored.ID, stored)
+	return nil
+}
+
+// AttachImage adds a disk with a single unmounted partition for the image.
+func (f *FakeBackend) AttachImage(ctx context.Context, path string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	id := fmt.Sprintf("image%d", f.Disks.Len())
+	partitions := orderedmap.New[string, Partition]()
+	partitions.Set(id+"s1", Partition{ID: id + "s1", Name: imageName(path), Device: id + "s1"})
+	f.Disks.Set(id, Disk{ID: id, Name: imageName(path), Device: id, Partitions: partitions, Image: path})
+	return nil
+}
+
+func (f *FakeBackend) DetachImage(ctx context.Context, disk Disk) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if stored, ok := f.Disks.Get(disk.ID); !ok || stored.Image == "" {
+		return fmt.Errorf("no such disk image: %s", disk.ID)
+	}
+	f.Disks.Delete(disk.ID)
+	return nil
+}
+
+func (f *FakeBackend) Open(path string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.Opened = append(f.Opened, path)
+	return nil
+}
+
+func (f *FakeBackend) Info(ctx context.Context, id string) (map[string]interface{}, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if disk, ok := f.Disks.Get(id); ok {
+		return map[string]interface{}{
+			"ID":   disk.ID,
+			"Name": disk.Name,
+			"Size": disk.Size,
+		}, nil
+	}
+	_, partition, ok := f.find(id)
+	if !ok {
+		return nil, fmt.Errorf("no such disk or partition: %s", id)
+	}
+	return map[string]interface{}{
+		"ID":         partition.ID,
+		"Name":       partition.Name,
+		"Size":       partition.Size,
+		"Device":     partition.Device,
+		"MountPoint": partition.MountPoint,
+	}, nil
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"os"
+	"strings"
+
+	"github.com/therecipe/qt/widgets"
+)
+
+var ErrBootDisk = userErrorf("refusing to erase the disk the system runs from")
+
+// systemMountPoints are where the running system is mounted. A disk holding
+// any of them is the boot disk.
+var systemMountPoints = []string{"/", "/boot", "/boot/efi", "/efi", "/usr", "/var", "[SWAP]"}
+
+func isBootDisk(disk Disk) bool {
+	systemDrive := os.Getenv("SystemDrive")
+	for _, partition := range disk.Volumes() {
+		mountPoint := partition.MountPoint
+		if systemDrive != "" && strings.EqualFold(strings.TrimSuffix(mountPoint, `\`), systemDrive) {
+			return true
+		}
+		for _, system := range systemMountPoints {
+			if mountPoint == system {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// confirmationName is what has to be typed to confirm erasing device: its
+// name without /dev/ or \\.\, or the drive letter of a Windows volume, whose
+// device is an unwieldy GUID path.
+func confirmationName(device string, mountPoint string) string {
+	if windowsIsDriveRoot(mountPoint) {
+		return strings.TrimSuffix(mountPoint, `\`)
+	}
+	device = strings.TrimPrefix(device, "/dev/")
+	return strings.TrimPrefix(device, `\\.\`)
+}
+
+// showFormatWizard asks how to format title, then asks to type device as a
+// confirmation. Without a partition scheme only the partition is formatted,
+// so the scheme is not asked for. A label the filesystem cannot hold is
+// refused before the confirmation page.
+func showFormatWizard(title string, device string, askScheme bool) (FormatOptions, bool) {
+	var (
+		wizard         = widgets.NewQWizard(window, 0)
+		optionsPage    = widgets.NewQWizardPage(nil)
+		optionsLayout  = widgets.NewQFormLayout(optionsPage)
+		filesystemBox  = widgets.NewQComboBox(nil)
+		labelEdit      = widgets.NewQLineEdit(nil)
+		schemeBox      = widgets.NewQComboBox(nil)
+		confirmPage    = widgets.NewQWizardPage(nil)
+		confirmLayout  = widgets.NewQVBoxLayout2(confirmPage)
+		confirmLabel   = widgets.NewQLabel2(trf("Everything on %s will be erased. Type %s to confirm.", title, device), nil, 0)
+		confirmEdit    = widgets.NewQLineEdit(nil)
+		filesystems    = backend.Filesystems()
+		schemes        = []string{"GPT", "MBR"}
+		confirmMatches = func() bool { return confirmEdit.Text() == device }
+		label          string
+	)
+	wizard.SetWindowTitle(trf("Format %s", title))
+	filesystemBox.AddItems(filesystems)
+	schemeBox.AddItems(schemes)
+	optionsPage.SetTitle(tr("Options"))
+	optionsLayout.AddRow3(tr("Filesystem:"), filesystemBox)
+	optionsLayout.AddRow3(tr("Label:"), labelEdit)
+	if askScheme {
+		optionsLayout.AddRow3(tr("Partition scheme:"), schemeBox)
+	}
+	optionsPage.ConnectValidatePage(func() bool {
+		// an empty label formats the volume without one
+		label = strings.TrimSpace(labelEdit.Text())
+		if label == "" {
+			return true
+		}
+		var err error
+		if label, err = validateLabel(filesystemBox.CurrentText(), label); err != nil {
+			widgets.QMessageBox_Warning(wizard, wizard.WindowTitle(), errorText(err), widgets.QMessageBox__Ok, widgets.QMessageBox__Ok)
+			return false
+		}
+		return true
+	})
+
+	confirmPage.SetTitle(tr("Confirm"))
+	confirmLabel.SetWordWrap(true)
+	confirmLayout.AddWidget(confirmLabel, 0, 0)
+	confirmLayout.AddWidget(confirmEdit, 0, 0)
+	confirmPage.ConnectIsComplete(confirmMatches)
+	confirmEdit.ConnectTextChanged(func(string) {
+		confirmPage.CompleteChanged()
+	})
+
+	wizard.AddPage(optionsPage)
+	wizard.AddPage(confirmPage)
+	wizard.SetButtonText(widgets.QWizard__FinishButton, tr("Erase"))
+	accepted := wizard.Exec() == int(widgets.QDialog__Accepted) && confirmMatches()
+	options := FormatOptions{
+		Filesystem: filesystemBox.CurrentText(),
+		Label:      label,
+	}
+	if askScheme {
+		options.Scheme = schemes[schemeBox.CurrentIndex()]
+	}
+	wizard.DeleteLater()
+	return options, accepted
+}
+
+// formatDisk erases the card's disk after the wizard, unless it is the boot
+// disk.
+func (card *diskCard) formatDisk() {
+	disk := card.disk
+	if isBootDisk(disk) {
+		card.showError(ErrBootDisk)
+		return
+	}
+	options, ok := showFormatWizard(disk.Name, confirmationName(disk.Device, ""), true)
+	if !ok {
+		return
+	}
+	card.runUninterrupted(trf("Erasing %s…", disk.Name), func(ctx context.Context) error {
+		return backend.FormatDisk(ctx, disk, options)
+	})
+}
+
+func (card *diskCard) formatPartition(partition Partition) {
+	if isBootDisk(card.disk) {
+		card.showError(ErrBootDisk)
+		return
+	}
+	title := partitionDisplayName(partition)
+	options, ok := showFormatWizard(title, confirmationName(partition.Device, partition.MountPoint), false)
+	if !ok {
+		return
+	}
+	card.runUninterrupted(trf("Formatting %s…", title), func(ctx context.Context) error {
+		return backend.FormatPartition(ctx, partition, options)
+	})
+}
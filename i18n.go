@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// catalogues holds the translations of the user interface, keyed by language
+// and then by the English text. English needs no catalogue of its own.
+var catalogues = map[string]map[string]string{
+	"de": catalogueDE,
+}
+
+// catalogue is the catalogue of the language in use, nil for English.
+var catalogue map[string]string
+
+// setLanguage picks the catalogue for a locale name such as "de_DE" or
+// "de-AT". Languages without a catalogue fall back to English.
+func setLanguage(locale string) {
+	language, _, _ := strings.Cut(strings.ReplaceAll(locale, "-", "_"), "_")
+	catalogue = catalogues[strings.ToLower(language)]
+}
+
+// tr translates text, which is also the key into the catalogue. Texts
+// missing from the catalogue are shown in English.
+func tr(text string) string {
+	if translation, ok := catalogue[text]; ok {
+		return translation
+	}
+	return text
+}
+
+// trf translates format before formatting it, so the translation may move the
+// arguments around.
+func trf(format string, args ...interface{}) string {
+	return fmt.Sprintf(tr(format), args...)
+}
+
+// userError is an error meant for the user rather than a log, such as a
+// label the filesystem cannot store. Error returns the English text, while
+// errorText shows it in the user's language, so format is a catalogue key.
+type userError struct {
+	format string
+	args   []interface{}
+}
+
+// userErrorf returns a userError, formatted like fmt.Errorf without %w.
+func userErrorf(format string, args ...interface{}) error {
+	return &userError{format: format, args: args}
+}
+
+func (e *userError) Error() string {
+	return fmt.Sprintf(e.format, e.args...)
+}
+
+// Text is the error in the user's language.
+func (e *userError) Text() string {
+	return trf(e.format, e.args...)
+}
@@ -0,0 +1,166 @@
+package main
+
+var catalogueDE = map[string]string{
+	// main window
+	"App":          "App",
+	"Reload":       "Neu laden",
+	"Preferences…": "Einstellungen…",
+	"View":         "Ansicht",
+	"Cards":        "Karten",
+	"Tree":         "Baum",
+	"Scanning…":    "Suche Laufwerke…",
+	"Cancel":       "Abbrechen",
+
+	// disk images
+	"Attach Disk Image…": "Image anhängen…",
+	"Attach Disk Image":  "Image anhängen",
+	"Attaching %s…":      "%s wird angehängt…",
+	imageFilter:          "Images (*.dmg *.sparseimage *.sparsebundle *.iso *.img *.vhd *.vhdx);;Alle Dateien (*)",
+
+	// disk cards and menus
+	"(No Name)":                 "(Ohne Namen)",
+	"Mount":                     "Einhängen",
+	"Unmount":                   "Aushängen",
+	"Eject":                     "Auswerfen",
+	"Open":                      "Öffnen",
+	"Unlock…":                   "Entsperren…",
+	"Lock":                      "Sperren",
+	"Unlocking %s…":             "%s wird entsperrt…",
+	"Locking %s…":               "%s wird gesperrt…",
+	"Unlock %s":                 "%s entsperren",
+	"Unlock":                    "Entsperren",
+	"Password or recovery key:": "Passwort oder Wiederherstellungsschlüssel:",
+	"Edit Partitions…":          "Partitionen bearbeiten…",
+	"Reading partitions of %s…": "Partitionen von %s werden gelesen…",
+	"Editing partitions of %s…": "Partitionen von %s werden geändert…",
+	"Partitions of %s":          "Partitionen von %s",
+	"Partition":                 "Partition",
+	"Start":                     "Beginn",
+	"Unallocated":               "Nicht zugeordnet",
+	"Create…":                   "Erstellen…",
+	"Delete":                    "Löschen",
+	"Resize…":                   "Größe ändern…",
+	"Undo":                      "Rückgängig",
+	"Pending changes:":          "Ausstehende Änderungen:",
+	"Resize Partition":          "Partitionsgröße ändern",
+	"Create Partition":          "Partition erstellen",
+	"Size:":                     "Größe:",
+	"Apply Changes":             "Änderungen anwenden",
+	"Create %s (%s, %s)":        "%s erstellen (%s, %s)",
+	"Delete %s":                 "%s löschen",
+	"Resize %s to %s":           "Größe von %s auf %s ändern",
+	"These changes will be made to %s and cannot be undone:":               "Diese Änderungen werden an %s vorgenommen und können nicht rückgängig gemacht werden:",
+	"The system runs from this disk, so its partitions cannot be changed.": "Das System läuft von diesem Laufwerk, daher können seine Partitionen nicht geändert werden.",
+	"Rename…":                  "Umbenennen…",
+	"Renaming %s…":             "%s wird umbenannt…",
+	"Verify":                   "Überprüfen",
+	"Repair":                   "Reparieren",
+	"Verifying %s…":            "%s wird überprüft…",
+	"Repairing %s…":            "%s wird repariert…",
+	"Verify %s":                "%s überprüfen",
+	"Repair %s":                "%s reparieren",
+	"No problems were found.":  "Es wurden keine Probleme gefunden.",
+	"The volume was repaired.": "Das Volume wurde repariert.",
+	"Repair Volume":            "Volume reparieren",
+	"%s could not be mounted because its filesystem is damaged. Repair it now?": "%s konnte nicht eingehängt werden, weil sein Dateisystem beschädigt ist. Jetzt reparieren?",
+	"Erase…":            "Löschen…",
+	"Format…":           "Formatieren…",
+	"Erasing %s…":       "%s wird gelöscht…",
+	"Formatting %s…":    "%s wird formatiert…",
+	"Format %s":         "%s formatieren",
+	"Options":           "Optionen",
+	"Filesystem:":       "Dateisystem:",
+	"Label:":            "Name:",
+	"Partition scheme:": "Partitionsschema:",
+	"Confirm":           "Bestätigen",
+	"Erase":             "Löschen",
+	"Everything on %s will be erased. Type %s to confirm.": "Alle Daten auf %s werden gelöscht. Zum Bestätigen %s eingeben.",
+	"Mount Read-Only":        "Schreibgeschützt einhängen",
+	"%s (read-only)":         "%s (schreibgeschützt)",
+	"Mount to Folder…":       "In Ordner einhängen…",
+	"Choose an empty folder": "Leeren Ordner auswählen",
+	"Properties…":            "Eigenschaften…",
+	"Mounting %s…":           "%s wird eingehängt…",
+	"Unmounting %s…":         "%s wird ausgehängt…",
+	"Detach":                 "Trennen",
+	"Detaching %s…":          "%s wird getrennt…",
+	"Ejecting %s…":           "%s wird ausgeworfen…",
+	"%s free":                "%s frei",
+	"%s used, %s free":       "%s belegt, %s frei",
+	"Details":                "Details",
+	"Dismiss":                "Schließen",
+
+	// tree view
+	"Name":        "Name",
+	"Device":      "Gerät",
+	"Filesystem":  "Dateisystem",
+	"Label":       "Bezeichnung",
+	"UUID":        "UUID",
+	"Size":        "Größe",
+	"Free":        "Frei",
+	"Mount Point": "Einhängepunkt",
+	"Flags":       "Merkmale",
+	"container":   "Container",
+	"mounted":     "eingehängt",
+	"read-only":   "schreibgeschützt",
+	"encrypted":   "verschlüsselt",
+	"locked":      "gesperrt",
+
+	// properties
+	"Reading properties of %s…": "Eigenschaften von %s werden gelesen…",
+	"%s Properties":             "Eigenschaften von %s",
+	"Property":                  "Eigenschaft",
+	"Value":                     "Wert",
+	"Copy":                      "Kopieren",
+	"Export…":                   "Exportieren…",
+	"Export":                    "Exportieren",
+	"Export Properties":         "Eigenschaften exportieren",
+	"Yes":                       "Ja",
+	"No":                        "Nein",
+
+	// volume settings and preferences
+	"Mount automatically when Qartion starts": "Beim Start von Qartion automatisch einhängen",
+	"Open the folder after mounting":          "Ordner nach dem Einhängen öffnen",
+	"Always mount read-only":                  "Immer schreibgeschützt einhängen",
+	"Browse…":                                 "Durchsuchen…",
+	"Chosen by the system":                    "Vom System gewählt",
+	"Mount point:":                            "Einhängepunkt:",
+	"Preferences":                             "Einstellungen",
+	"Warn when free space is below:":          "Warnen bei freiem Speicher unter:",
+	"Size units:":                             "Größeneinheiten:",
+	"Decimal (kB, MB, GB)":                    "Dezimal (kB, MB, GB)",
+	"Binary (KiB, MiB, GiB)":                  "Binär (KiB, MiB, GiB)",
+	"Decimal places:":                         "Nachkommastellen:",
+
+	// errors
+	"the label cannot be empty":                                 "der Name darf nicht leer sein",
+	"cannot rename %s volumes":                                  "%s-Volumes können nicht umbenannt werden",
+	"%s labels cannot contain %q":                               "%s-Namen dürfen kein %q enthalten",
+	"%s labels can be at most %d bytes long":                    "%s-Namen dürfen höchstens %d Bytes lang sein",
+	"%s labels can be at most %d characters long":               "%s-Namen dürfen höchstens %d Zeichen lang sein",
+	"cannot mount on %s: %s":                                    "kann nicht in %s eingehängt werden: %s",
+	"cannot mount on %s: the folder is not empty":               "kann nicht in %s eingehängt werden: der Ordner ist nicht leer",
+	"all drive letters from D: to Z: are in use":                "alle Laufwerksbuchstaben von D: bis Z: sind belegt",
+	"refusing to erase the disk the system runs from":           "das Laufwerk, von dem das System läuft, wird nicht gelöscht",
+	"%s has no partition table Qartion can edit":                "%s hat keine Partitionstabelle, die Qartion bearbeiten kann",
+	"%s has no partition map Qartion can edit":                  "%s hat keine Partitionstabelle, die Qartion bearbeiten kann",
+	"the partition would overlap another partition":             "die Partition würde eine andere Partition überlappen",
+	"the partition would not fit on the disk":                   "die Partition würde nicht auf das Laufwerk passen",
+	"the partition would be smaller than 1 MiB":                 "die Partition wäre kleiner als 1 MiB",
+	"the partition table has no room for another partition":     "die Partitionstabelle hat keinen Platz für eine weitere Partition",
+	"no such partition":                                         "Partition nicht gefunden",
+	"Qartion can only resize ext filesystems, not %s":           "Qartion kann nur die Größe von ext-Dateisystemen ändern, nicht von %s",
+	"diskutil can only add a partition right after another one": "diskutil kann eine Partition nur direkt nach einer anderen hinzufügen",
+
+	// error kinds
+	"command not found":                                  "Befehl nicht gefunden",
+	"permission denied":                                  "Zugriff verweigert",
+	"could not understand the command output":            "die Ausgabe des Befehls war unverständlich",
+	"device is busy":                                     "Gerät wird verwendet",
+	"administrator authorization was cancelled":          "Anmeldung als Administrator abgebrochen",
+	"cancelled":                                          "abgebrochen",
+	"timed out":                                          "Zeitüberschreitung",
+	"wrong password or recovery key":                     "Falsches Passwort oder falscher Wiederherstellungsschlüssel",
+	"the filesystem is damaged and needs to be repaired": "das Dateisystem ist beschädigt und muss repariert werden",
+	"command failed":                                     "Befehl fehlgeschlagen",
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	orderedmap "github.com/wk8/go-ordered-map/v2"
+)
+
+// translatedTexts returns every text the user interface passes through tr,
+// trf or userErrorf, with the position it is used at. Besides the literals
+// and constants handed to them in the sources, these are the column titles, the error
+// kinds and the partition flags, which are translated from variables.
+func translatedTexts(t *testing.T) map[string]string {
+	files, err := filepath.Glob("*.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var (
+		fset      = token.NewFileSet()
+		parsed    []*ast.File
+		constants = make(map[string]string)
+		texts     = make(map[string]string)
+	)
+	for _, name := range files {
+		if strings.HasSuffix(name, "_test.go") {
+			continue
+		}
+		file, err := parser.ParseFile(fset, name, nil, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		parsed = append(parsed, file)
+		for _, decl := range file.Decls {
+			gen, ok := decl.(*ast.GenDecl)
+			if !ok || gen.Tok != token.CONST {
+				continue
+			}
+			for _, spec := range gen.Specs {
+				value := spec.(*ast.ValueSpec)
+				for i, name := range value.Names {
+					if i < len(value.Values) {
+						if lit, ok := value.Values[i].(*ast.BasicLit); ok && lit.Kind == token.STRING {
+							constants[name.Name], _ = strconv.Unquote(lit.Value)
+						}
+					}
+				}
+			}
+		}
+	}
+	for _, file := range parsed {
+		ast.Inspect(file, func(node ast.Node) bool {
+			call, ok := node.(*ast.CallExpr)
+			if !ok || len(call.Args) == 0 {
+				return true
+			}
+			if fun, ok := call.Fun.(*ast.Ident); !ok || (fun.Name != "tr" && fun.Name != "trf" && fun.Name != "userErrorf") {
+				return true
+			}
+			switch arg := call.Args[0].(type) {
+			case *ast.BasicLit:
+				if arg.Kind == token.STRING {
+					text, err := strconv.Unquote(arg.Value)
+					if err != nil {
+						t.Fatalf("%s: %v", fset.Position(arg.Pos()), err)
+					}
+					texts[text] = fset.Position(arg.Pos()).String()
+				}
+			case *ast.Ident:
+				if text, ok := constants[arg.Name]; ok {
+					texts[text] = fset.Position(arg.Pos()).String()
+				}
+			}
+			return true
+		})
+	}
+
+	for _, title := range columnTitles {
+		texts[title] = "columnTitles"
+	}
+	// kinds past the last one are reported as KindUnknown
+	for kind := KindUnknown + 1; kind.String() != KindUnknown.String(); kind++ {
+		texts[kind.String()] = "ErrorKind.String"
+	}
+	container := orderedmap.New[string, Partition]()
+	container.Set("child", Partition{ID: "child"})
+	for _, partition := range []Partition{
+		{MountPoint: "/mnt", ReadOnly: true, Encrypted: true, Partitions: container},
+		{Locked: true},
+	} {
+		for _, flag := range partitionFlags(partition) {
+			texts[flag] = "partitionFlags"
+		}
+	}
+	return texts
+}
+
+func TestCatalogueDE(t *testing.T) {
+	texts := translatedTexts(t)
+	// guards against the scan silently finding nothing
+	if len(texts) < 100 {
+		t.Fatalf("found only %d texts to translate", len(texts))
+	}
+	for text, where := range texts {
+		if _, ok := catalogueDE[text]; !ok {
+			t.Errorf("%s: %q is missing from catalogueDE", where, text)
+		}
+	}
+}
+
+func TestErrorText(t *testing.T) {
+	defer setLanguage("en")
+	setLanguage("de_DE")
+	_, labelErr := validateLabel(FilesystemFAT32, "A:B")
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{ErrPlanOverlap, "die Partition würde eine andere Partition überlappen"},
+		{labelErr, `FAT32-Namen dürfen kein ':' enthalten`},
+		{&CommandError{Kind: KindDeviceBusy, Op: "unmount sdb1"}, "unmount sdb1: Gerät wird verwendet"},
+		{&CommandError{Op: "unmount sdb1", Stderr: "target is busy"}, "unmount sdb1: target is busy"},
+	}
+	for _, test := range tests {
+		if got := errorText(test.err); got != test.want {
+			t.Errorf("errorText(%v) = %q, want %q", test.err, got, test.want)
+		}
+	}
+	// Error stays in English, for logs and the command line
+	if got := labelErr.Error(); got != `FAT32 labels cannot contain ':'` {
+		t.Errorf("Error() = %q", got)
+	}
+}
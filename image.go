@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"strings"
+
+	"github.com/therecipe/qt/widgets"
+)
+
+// imageFilter lists the disk image formats the backends attach, although
+// which of them work depends on the platform.
+const imageFilter = "Disk images (*.dmg *.sparseimage *.sparsebundle *.iso *.img *.vhd *.vhdx);;All files (*)"
+
+// imageName returns the file name of a disk image path, which may use either
+// kind of slash whatever the platform Qartion runs on.
+func imageName(path string) string {
+	return path[strings.LastIndexAny(path, `/\`)+1:]
+}
+
+// attachImage asks for a disk image file and attaches it. The image then
+// shows up as a card of its own, with its volumes mounted if their settings
+// say so.
+func attachImage(l *widgets.QGridLayout) {
+	path := widgets.QFileDialog_GetOpenFileName(window, tr("Attach Disk Image"), "", tr(imageFilter), "", 0)
+	if path == "" {
+		return
+	}
+	runTask(trf("Attaching %s…", imageName(path)), operationTimeout, func(ctx context.Context) func() {
+		if err := backend.AttachImage(ctx, path); err != nil {
+			return func() {
+				listBanner.ShowError(err)
+			}
+		}
+		disks, err := listDisks(ctx)
+		if err != nil {
+			return func() {
+				listBanner.ShowError(err)
+			}
+		}
+		errs := autoMount(ctx, disks)
+		return func() {
+			applyDisks(l, disks, errs)
+		}
+	})
+}
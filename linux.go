@@ -0,0 +1,415 @@
+//go:build linux
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"strconv"
+	"strings"
+
+	orderedmap "github.com/wk8/go-ordered-map/v2"
+)
+
+func LinuxGetDisks(ctx context.Context) (*orderedmap.OrderedMap[string, Disk], error) {
+	cmd := exec.Command("lsblk", "--json", "--bytes", "-o", "NAME,PATH,SIZE,TYPE,LABEL,UUID,FSTYPE,MOUNTPOINT,PKNAME,MODEL")
+	output, err := runCommand(ctx, "list disks", cmd)
+	if err != nil {
+		return nil, err
+	}
+	return linuxParseLsblk(output)
+}
+
+type losetupOutput struct {
+	LoopDevices []struct {
+		Name     string `json:"name"`
+		BackFile string `json:"back-file"`
+	} `json:"loopdevices"`
+}
+
+// linuxAddImages marks the loop devices with the path of the file backing
+// them, which also becomes their name. Without losetup the images simply show
+// up as plain loop devices.
+func linuxAddImages(ctx context.Context, disks *orderedmap.OrderedMap[string, Disk]) error {
+	output, err := runCommand(ctx, "list disk images", exec.Command("losetup", "--json", "--list", "--output", "NAME,BACK-FILE"))
+	if KindOf(err) == KindCommandNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if len(bytes.TrimSpace(output)) == 0 {
+		// losetup prints nothing at all when no loop device is set up
+		return nil
+	}
+	var data losetupOutput
+	if err := json.Unmarshal(output, &data); err != nil {
+		return parseError("list disk images", output, err)
+	}
+	for _, loop := range data.LoopDevices {
+		disk, ok := disks.Get(loop.Name)
+		if !ok || loop.BackFile == "" {
+			continue
+		}
+		disk.Image = loop.BackFile
+		disk.Name = imageName(loop.BackFile)
+		disks.Set(disk.ID, disk)
+	}
+	return nil
+}
+
+type linuxBackend struct{}
+
+func (linuxBackend) ListDisks(ctx context.Context) (*orderedmap.OrderedMap[string, Disk], error) {
+	disks, err := LinuxGetDisks(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := linuxAddImages(ctx, disks); err != nil {
+		return nil, err
+	}
+	addMountState(disks)
+	return disks, nil
+}
+
+func (linuxBackend) Open(path string) error {
+	_, err := runCommand(context.Background(), "open "+path, exec.Command("xdg-open", path))
+	return err
+}
+
+func (linuxBackend) Mount(ctx context.Context, partition Partition, options MountOptions) (Partition, error) {
+	op := "mount " + partition.Device
+	if options.Path != "" {
+		// udisks always picks the mount point itself
+		if err := validateMountFolder(options.Path); err != nil {
+			return partition, err
+		}
+		args := []string{"mount"}
+		if options.ReadOnly {
+			args = append(args, "-o", "ro")
+		}
+		args = append(args, partition.Device, options.Path)
+		if _, err := runCommand(ctx, op, exec.Command("pkexec", args...)); err != nil {
+			return partition, err
+		}
+		partition.MountPoint = options.Path
+		partition.ReadOnly = options.ReadOnly
+		return partition, nil
+	}
+	args := []string{"mount", "-b", partition.Device}
+	if options.ReadOnly {
+		args = append(args, "-o", "ro")
+	}
+	output, err := runCommand(ctx, op, exec.Command("udisksctl", args...))
+	if err != nil {
+		return partition, err
+	}
+	partition.MountPoint = linuxParseMountPoint(string(output))
+	partition.ReadOnly = options.ReadOnly
+	if partition.MountPoint == "" {
+		return partition, parseError(op, output, fmt.Errorf("no mount point in udisksctl output"))
+	}
+	return partition, nil
+}
+
+func (linuxBackend) Unmount(ctx context.Context, partition Partition) (Partition, error) {
+	op := "unmount " + partition.Device
+	_, err := runCommand(ctx, op, exec.Command("udisksctl", "unmount", "-b", partition.Device))
+	if KindOf(err) == KindCommandNotFound {
+		_, err = runCommand(ctx, op, exec.Command("umount", partition.MountPoint))
+	}
+	if err != nil {
+		return partition, err
+	}
+	partition.MountPoint = ""
+	partition.ReadOnly = false
+	return partition, nil
+}
+
+func (b linuxBackend) Eject(ctx context.Context, disk Disk) error {
+	for _, partition := range disk.Volumes() {
+		if partition.MountPoint == "" {
+			continue
+		}
+		if _, err := b.Unmount(ctx, partition); err != nil {
+			return err
+		}
+	}
+	_, err := runCommand(ctx, "eject "+disk.Device, exec.Command("udisksctl", "power-off", "-b", disk.Device))
+	return err
+}
+
+func (b linuxBackend) Unlock(ctx context.Context, partition Partition, passphrase string) error {
+	op := "unlock " + partition.Device
+	cmd := exec.Command("udisksctl", "unlock", "-b", partition.Device, "--key-file", "/dev/stdin")
+	cmd.Stdin = strings.NewReader(passphrase)
+	output, err := runCommand(ctx, op, cmd)
+	if err != nil {
+		return err
+	}
+	cleartext := linuxParseCleartext(string(output))
+	if cleartext == "" {
+		return parseError(op, output, fmt.Errorf("no unlocked device in udisksctl output"))
+	}
+	_, err = b.Mount(ctx, Partition{ID: cleartext, Device: cleartext}, MountOptions{})
+	return err
+}
+
+func (b linuxBackend) Lock(ctx context.Context, partition Partition) error {
+	if err := b.unmountAll(ctx, volumes(partition.Partitions)); err != nil {
+		return err
+	}
+	_, err := runCommand(ctx, "lock "+partition.Device, exec.Command("udisksctl", "lock", "-b", partition.Device))
+	return err
+}
+
+func (linuxBackend) Filesystems() []string {
+	return []string{FilesystemExt4, FilesystemExFAT, FilesystemFAT32, FilesystemNTFS}
+}
+
+// linuxFormatDiskScript writes a new partition table with one partition and
+// waits for its device to appear before running the mkfs command passed after
+// the first three arguments, so that pkexec asks only once.
+const linuxFormatDiskScript = `parted -s "$1" mklabel "$2" mkpart primary "$3" 1MiB 100% && udevadm settle && shift 3 && exec "$@"`
+
+// unmountAll unmounts every mounted volume in partitions.
+func (b linuxBackend) unmountAll(ctx context.Context, partitions []Partition) error {
+	for _, partition := range partitions {
+		if partition.MountPoint == "" {
+			continue
+		}
+		if _, err := b.Unmount(ctx, partition); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b linuxBackend) FormatDisk(ctx context.Context, disk Disk, options FormatOptions) error {
+	mkfs, err := linuxMkfs(options, linuxPartitionDevice(disk.Device, 1))
+	if err != nil {
+		return err
+	}
+	if err := b.unmountAll(ctx, disk.Volumes()); err != nil {
+		return err
+	}
+	scheme := "gpt"
+	if options.Scheme == "MBR" {
+		scheme = "msdos"
+	}
+	// parted has no exFAT type, and NTFS is what marks it on MBR disks
+	hint := map[string]string{FilesystemExt4: "ext4", FilesystemFAT32: "fat32"}[options.Filesystem]
+	if hint == "" {
+		hint = "ntfs"
+	}
+	args := append([]string{"sh", "-c", linuxFormatDiskScript, "sh", disk.Device, scheme, hint}, mkfs...)
+	_, err = runCommand(ctx, "erase "+disk.Device, exec.Command("pkexec", args...))
+	return err
+}
+
+func (b linuxBackend) FormatPartition(ctx context.Context, partition Partition, options FormatOptions) error {
+	mkfs, err := linuxMkfs(options, partition.Device)
+	if err != nil {
+		return err
+	}
+	if err := b.unmountAll(ctx, append(volumes(partition.Partitions), partition)); err != nil {
+		return err
+	}
+	_, err = runCommand(ctx, "format "+partition.Device, exec.Command("pkexec", mkfs...))
+	return err
+}
+
+// linuxLabelTools are the commands that change the label of a filesystem,
+// keyed by its lsblk name. They take the device and then the label.
+var linuxLabelTools = map[string]string{
+	"vfat":  "fatlabel",
+	"exfat": "exfatlabel",
+	"ntfs":  "ntfslabel",
+	"ext2":  "e2label",
+	"ext3":  "e2label",
+	"ext4":  "e2label",
+}
+
+// Rename relabels the volume with the tool for its filesystem. Only ext
+// filesystems can be relabelled while mounted, so other volumes are unmounted
+// and mounted again afterwards, which also moves their mount point to the
+// new label.
+func (b linuxBackend) Rename(ctx context.Context, partition Partition, label string) error {
+	label, err := validateLabel(partition.Filesystem, label)
+	if err != nil {
+		return err
+	}
+	tool := linuxLabelTools[partition.Filesystem]
+	if tool == "" {
+		return fmt.Errorf("cannot rename %s volumes", partition.Filesystem)
+	}
+	remount, readOnly := partition.MountPoint != "" && tool != "e2label", partition.ReadOnly
+	if remount {
+		if partition, err = b.Unmount(ctx, partition); err != nil {
+			return err
+		}
+	}
+	_, err = runCommand(ctx, "rename "+partition.Device, exec.Command("pkexec", tool, partition.Device, label))
+	if remount {
+		if _, mountErr := b.Mount(ctx, partition, MountOptions{ReadOnly: readOnly}); err == nil {
+			err = mountErr
+		}
+	}
+	return err
+}
+
+// linuxCheck unmounts the partition, as the checkers refuse or give false
+// alarms on a mounted filesystem, and runs the checker as root. fsck exits
+// with 1 when it fixed errors and with 4 when errors are left.
+func (b linuxBackend) linuxCheck(ctx context.Context, op string, partition Partition, repair bool, output func(line string)) error {
+	if err := b.unmountAll(ctx, []Partition{partition}); err != nil {
+		return err
+	}
+	err := streamCommand(ctx, op, exec.Command("pkexec", linuxCheckCommand(partition, repair)...), output)
+	switch code := exitCode(err); {
+	case repair && code == 1:
+		return nil
+	case code == 4:
+		err.(*CommandError).Kind = KindCorrupted
+	}
+	return err
+}
+
+func (b linuxBackend) Verify(ctx context.Context, partition Partition, output func(line string)) error {
+	return b.linuxCheck(ctx, "verify "+partition.Device, partition, false, output)
+}
+
+func (b linuxBackend) Repair(ctx context.Context, partition Partition, output func(line string)) error {
+	return b.linuxCheck(ctx, "repair "+partition.Device, partition, true, output)
+}
+
+type lsblkTable struct {
+	BlockDevices []struct {
+		Size     lsblkSize `json:"size"`
+		PTType   string    `json:"pttype"`
+		Children []struct {
+			Name   string    `json:"name"`
+			Size   lsblkSize `json:"size"`
+			Type   string    `json:"type"`
+			Label  string    `json:"label"`
+			FSType string    `json:"fstype"`
+		} `json:"children"`
+	} `json:"blockdevices"`
+}
+
+// linuxSysfsNumber reads a number sysfs keeps about a block device, such as
+// its partition number or its start in 512-byte sectors.
+func linuxSysfsNumber(name string, attribute string) (uint64, error) {
+	data, err := os.ReadFile(path.Join("/sys/class/block", name, attribute))
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+}
+
+func (linuxBackend) PartitionTable(ctx context.Context, disk Disk) (PartitionTable, error) {
+	op := "list partitions of " + disk.Device
+	output, err := runCommand(ctx, op, exec.Command("lsblk", "--json", "--bytes", "-o", "NAME,SIZE,TYPE,LABEL,FSTYPE,PTTYPE", disk.Device))
+	if err != nil {
+		return PartitionTable{}, err
+	}
+	var data lsblkTable
+	if err := json.Unmarshal(output, &data); err != nil || len(data.BlockDevices) != 1 {
+		return PartitionTable{}, parseError(op, output, err)
+	}
+	device := data.BlockDevices[0]
+	scheme := map[string]string{"gpt": "GPT", "dos": "MBR"}[device.PTType]
+	if scheme == "" {
+		return PartitionTable{}, userErrorf("%s has no partition table Qartion can edit", disk.Name)
+	}
+	var entries []TableEntry
+	for _, child := range device.Children {
+		if child.Type != "part" {
+			continue
+		}
+		number, err := linuxSysfsNumber(child.Name, "partition")
+		if err != nil {
+			return PartitionTable{}, err
+		}
+		start, err := linuxSysfsNumber(child.Name, "start")
+		if err != nil {
+			return PartitionTable{}, err
+		}
+		entries = append(entries, TableEntry{
+			Number:     int(number),
+			Name:       child.Label,
+			Filesystem: child.FSType,
+			Offset:     start * 512,
+			Size:       uint64(child.Size),
+		})
+	}
+	return newPartitionTable(scheme, uint64(device.Size), entries), nil
+}
+
+func (linuxBackend) CheckOperation(table PartitionTable, op Operation) error {
+	return linuxCheckOperation(table, op)
+}
+
+func (b linuxBackend) ApplyPlan(ctx context.Context, disk Disk, plan *Plan) error {
+	script, err := linuxPlanScript(disk.Device, plan.Base.Scheme, plan)
+	if err != nil {
+		return err
+	}
+	if err := b.unmountAll(ctx, disk.Volumes()); err != nil {
+		return err
+	}
+	_, err = runCommand(ctx, "edit partitions of "+disk.Device, exec.Command("pkexec", "sh", "-c", script))
+	return err
+}
+
+func (linuxBackend) AttachImage(ctx context.Context, path string) error {
+	op := "attach " + path
+	_, err := runCommand(ctx, op, exec.Command("udisksctl", "loop-setup", "-f", path))
+	if KindOf(err) == KindCommandNotFound {
+		_, err = runCommand(ctx, op, exec.Command("pkexec", "losetup", "--find", "--partscan", path))
+	}
+	return err
+}
+
+func (b linuxBackend) DetachImage(ctx context.Context, disk Disk) error {
+	if err := b.unmountAll(ctx, disk.Volumes()); err != nil {
+		return err
+	}
+	op := "detach " + disk.Image
+	_, err := runCommand(ctx, op, exec.Command("udisksctl", "loop-delete", "-b", disk.Device))
+	if KindOf(err) == KindCommandNotFound {
+		_, err = runCommand(ctx, op, exec.Command("pkexec", "losetup", "--detach", disk.Device))
+	}
+	return err
+}
+
+func (linuxBackend) Watcher() Watcher {
+	return newCommandWatcher(linuxParseUdevEvent, "udevadm", "monitor", "--udev", "--subsystem-match=block")
+}
+
+func (linuxBackend) Info(ctx context.Context, id string) (map[string]interface{}, error) {
+	op := "get info for " + id
+	output, err := runCommand(ctx, op, exec.Command("lsblk", "--json", "--bytes", "-O", id))
+	if err != nil {
+		return nil, err
+	}
+	var data struct {
+		BlockDevices []map[string]interface{} `json:"blockdevices"`
+	}
+	decoder := json.NewDecoder(bytes.NewReader(output))
+	decoder.UseNumber()
+	if err := decoder.Decode(&data); err != nil {
+		return nil, parseError(op, output, err)
+	}
+	if len(data.BlockDevices) == 0 {
+		return nil, parseError(op, output, fmt.Errorf("no such block device: %s", id))
+	}
+	info := data.BlockDevices[0]
+	delete(info, "children")
+	return info, nil
+}
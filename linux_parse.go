@@ -0,0 +1,294 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+
+	orderedmap "github.com/wk8/go-ordered-map/v2"
+)
+
+type lsblkOutput struct {
+	BlockDevices []lsblkDevice `json:"blockdevices"`
+}
+
+type lsblkDevice struct {
+	Name       string        `json:"name"`
+	Path       string        `json:"path"`
+	Size       lsblkSize     `json:"size"`
+	Type       string        `json:"type"`
+	Label      string        `json:"label"`
+	UUID       string        `json:"uuid"`
+	FSType     string        `json:"fstype"`
+	MountPoint string        `json:"mountpoint"`
+	PKName     string        `json:"pkname"`
+	Model      string        `json:"model"`
+	Children   []lsblkDevice `json:"children"`
+}
+
+// lsblkSize accepts both the numeric sizes printed by recent util-linux
+// releases and the quoted strings printed by older ones.
+type lsblkSize uint64
+
+func (s *lsblkSize) UnmarshalJSON(data []byte) error {
+	str := strings.Trim(string(data), `"`)
+	if str == "null" || str == "" {
+		*s = 0
+		return nil
+	}
+	size, err := strconv.ParseUint(str, 10, 64)
+	if err != nil {
+		return err
+	}
+	*s = lsblkSize(size)
+	return nil
+}
+
+func linuxParseLsblk(output []byte) (*orderedmap.OrderedMap[string, Disk], error) {
+	var data lsblkOutput
+	if err := json.Unmarshal(output, &data); err != nil {
+		return nil, parseError("list disks", output, err)
+	}
+	disks := orderedmap.New[string, Disk]()
+	for _, dev := range data.BlockDevices {
+		switch dev.Type {
+		case "disk", "loop", "rom":
+		default:
+			continue
+		}
+		if dev.Size == 0 {
+			continue
+		}
+		name := strings.TrimSpace(dev.Model)
+		if name == "" {
+			name = dev.Name
+		}
+		partitions := orderedmap.New[string, Partition]()
+		if dev.FSType != "" {
+			// filesystem written directly on the disk, without a partition table
+			whole := linuxPartition(dev)
+			whole.Partitions = nil
+			partitions.Set(dev.Path, whole)
+		}
+		for _, child := range dev.Children {
+			partitions.Set(child.Path, linuxPartition(child))
+		}
+		disks.Set(dev.Path, Disk{
+			ID:         dev.Path,
+			Name:       name,
+			Size:       uint64(dev.Size),
+			Type:       dev.Type,
+			Device:     dev.Path,
+			Partitions: partitions,
+		})
+	}
+	return disks, nil
+}
+
+// linuxPartition converts a partition along with the devices stacked on it,
+// such as an unlocked LUKS mapping or LVM logical volumes.
+func linuxPartition(dev lsblkDevice) Partition {
+	var children *orderedmap.OrderedMap[string, Partition]
+	if len(dev.Children) > 0 {
+		children = orderedmap.New[string, Partition]()
+		for _, child := range dev.Children {
+			children.Set(child.Path, linuxPartition(child))
+		}
+	}
+	// a LUKS partition only has the mapping of its contents as a child while
+	// it is unlocked
+	encrypted := dev.FSType == "crypto_LUKS"
+	return Partition{
+		ID:         dev.Path,
+		Type:       dev.Type,
+		Name:       dev.Label,
+		Size:       uint64(dev.Size),
+		Device:     dev.Path,
+		UUID:       dev.UUID,
+		Filesystem: dev.FSType,
+		MountPoint: dev.MountPoint,
+		Partitions: children,
+		Encrypted:  encrypted,
+		Locked:     encrypted && len(dev.Children) == 0,
+	}
+}
+
+// linuxParseMountPoint extracts the mount point from udisksctl output such as
+// "Mounted /dev/sdb1 at /media/user/USB."
+func linuxParseMountPoint(output string) string {
+	output = strings.TrimSpace(output)
+	index := strings.Index(output, " at ")
+	if index < 0 {
+		return ""
+	}
+	return strings.TrimSuffix(output[index+len(" at "):], ".")
+}
+
+// linuxParseCleartext extracts the unlocked device from udisksctl output such
+// as "Unlocked /dev/sdb1 as /dev/dm-0."
+func linuxParseCleartext(output string) string {
+	return linuxParseMountPoint(strings.Replace(output, " as ", " at ", 1))
+}
+
+// linuxMkfs returns the command line creating the filesystem on device.
+func linuxMkfs(options FormatOptions, device string) ([]string, error) {
+	var args []string
+	labelFlag := "-L"
+	label := options.Label
+	switch options.Filesystem {
+	case FilesystemExt4:
+		args = []string{"mkfs.ext4", "-F"}
+	case FilesystemExFAT:
+		args = []string{"mkfs.exfat"}
+	case FilesystemFAT32:
+		args = []string{"mkfs.vfat", "-F", "32"}
+		labelFlag, label = "-n", strings.ToUpper(label)
+	case FilesystemNTFS:
+		// -f skips zeroing the whole partition
+		args = []string{"mkfs.ntfs", "-f"}
+	default:
+		return nil, fmt.Errorf("cannot create %s on Linux", options.Filesystem)
+	}
+	if label != "" {
+		args = append(args, labelFlag, label)
+	}
+	return append(args, device), nil
+}
+
+// linuxPartitionDevice returns the device of a partition on disk, which gets
+// a "p" before the number when the disk name ends in a digit, as in
+// /dev/nvme0n1p1.
+func linuxPartitionDevice(disk string, number int) string {
+	if last := disk[len(disk)-1]; last >= '0' && last <= '9' {
+		return disk + "p" + strconv.Itoa(number)
+	}
+	return disk + strconv.Itoa(number)
+}
+
+// linuxCheckCommand returns the command line checking the filesystem on the
+// partition, and fixing it if repair is set. fsck picks the checker for the
+// filesystem, except for NTFS, which only ntfs-3g's ntfsfix can handle.
+func linuxCheckCommand(partition Partition, repair bool) []string {
+	if partition.Filesystem == "ntfs" {
+		if repair {
+			return []string{"ntfsfix", partition.Device}
+		}
+		return []string{"ntfsfix", "--no-action", partition.Device}
+	}
+	if repair {
+		return []string{"fsck", "-y", partition.Device}
+	}
+	return []string{"fsck", "-n", partition.Device}
+}
+
+// linuxShellQuote quotes an argument for sh.
+func linuxShellQuote(arg string) string {
+	return "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+}
+
+// linuxCheckOperation refuses to resize a partition whose filesystem is not
+// ext, as resize2fs is the only resizing tool linuxPlanScript runs. Empty
+// partitions can be resized, having no filesystem to keep.
+func linuxCheckOperation(table PartitionTable, op Operation) error {
+	if op.Kind != OperationResize {
+		return nil
+	}
+	i, ok := table.find(op.Entry.Number)
+	if !ok {
+		return ErrPlanNotFound
+	}
+	if filesystem := table.Entries[i].Filesystem; filesystem != "" && !strings.HasPrefix(filesystem, "ext") {
+		return userErrorf("Qartion can only resize ext filesystems, not %s", filesystem)
+	}
+	return nil
+}
+
+// linuxPlanScript turns the plan into one shell script, so that pkexec asks
+// only once and a failing step stops the ones after it. parted only changes
+// the partition table, so ext filesystems are resized with resize2fs, shrunk
+// before their partition and grown after it, and checked with e2fsck first
+// as resize2fs requires.
+func linuxPlanScript(device string, scheme string, plan *Plan) (string, error) {
+	var lines [][]string
+	for _, step := range plan.Steps() {
+		if err := linuxCheckOperation(step.Before, step.Operation); err != nil {
+			return "", err
+		}
+		entry := step.Operation.Entry
+		partition := linuxPartitionDevice(device, entry.Number)
+		number := strconv.Itoa(entry.Number)
+		end := strconv.FormatUint(entry.End()-1, 10) + "B"
+		switch step.Operation.Kind {
+		case OperationDelete:
+			lines = append(lines, []string{"parted", "-s", device, "rm", number})
+		case OperationCreate:
+			mkfs, err := linuxMkfs(FormatOptions{Filesystem: entry.Filesystem, Label: entry.Name}, partition)
+			if err != nil {
+				return "", err
+			}
+			// the name of a GPT partition, but its type on MBR disks
+			name := "primary"
+			if scheme == "GPT" && entry.Name != "" {
+				name = entry.Name
+			}
+			hint := map[string]string{FilesystemExt4: "ext4", FilesystemFAT32: "fat32"}[entry.Filesystem]
+			if hint == "" {
+				hint = "ntfs"
+			}
+			lines = append(lines,
+				[]string{"parted", "-s", "-a", "none", device, "unit", "B", "mkpart", name, hint, strconv.FormatUint(entry.Offset, 10) + "B", end},
+				[]string{"udevadm", "settle"},
+				mkfs)
+		case OperationResize:
+			before := step.Before.Entries
+			i, _ := step.Before.find(entry.Number)
+			filesystem := before[i].Filesystem
+			resizepart := []string{"parted", "-s", device, "unit", "B", "resizepart", number, end}
+			switch {
+			case filesystem == "":
+				lines = append(lines, resizepart)
+			case entry.Size < before[i].Size:
+				lines = append(lines,
+					[]string{"e2fsck", "-f", "-y", partition},
+					[]string{"resize2fs", partition, strconv.FormatUint(entry.Size/1024, 10) + "K"},
+					resizepart)
+			default:
+				lines = append(lines,
+					resizepart,
+					[]string{"udevadm", "settle"},
+					[]string{"e2fsck", "-f", "-y", partition},
+					[]string{"resize2fs", partition})
+			}
+		}
+	}
+	script := make([]string, len(lines))
+	for i, line := range lines {
+		quoted := make([]string, len(line))
+		for j, arg := range line {
+			quoted[j] = linuxShellQuote(arg)
+		}
+		script[i] = strings.Join(quoted, " ")
+	}
+	return "set -e\n" + strings.Join(script, "\n"), nil
+}
+
+// linuxParseUdevEvent parses a line of `udevadm monitor`, such as
+// "UDEV  [1234.567890] add      /devices/.../block/sdb/sdb1 (block)".
+func linuxParseUdevEvent(line string) (DeviceEvent, bool) {
+	fields := strings.Fields(line)
+	if len(fields) < 4 || fields[0] != "UDEV" {
+		return DeviceEvent{}, false
+	}
+	device := "/dev/" + path.Base(fields[3])
+	switch fields[2] {
+	case "add":
+		return DeviceEvent{Kind: DeviceAdded, Device: device}, true
+	case "remove":
+		return DeviceEvent{Kind: DeviceRemoved, Device: device}, true
+	case "change":
+		return DeviceEvent{Kind: DeviceChanged, Device: device}, true
+	}
+	return DeviceEvent{}, false
+}
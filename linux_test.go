@@ -0,0 +1,209 @@
+package main
+
+import "testing"
+
+func TestLinuxParseLsblk(t *testing.T) {
+	tests := []struct {
+		fixture string
+		want    []string
+	}{
+		{"lsblk-old-strings.json", []string{
+			`/dev/sda "WDC WD5000AAKX-0" 500107862016 disk`,
+			`  /dev/sda1 "boot" 524288000 ext4 dev=/dev/sda1 at=/boot [mounted]`,
+			`  /dev/sda2 "" 499582525440 ext4 dev=/dev/sda2 at=/ [mounted]`,
+			`/dev/sr0 "DVD+-RW GH24NSD1" 1073741312 rom`,
+		}},
+		{"lsblk-nvme.json", []string{
+			`/dev/nvme0n1 "Samsung SSD 970 EVO Plus 1TB" 1000204886016 disk`,
+			`  /dev/nvme0n1p1 "" 536870912 vfat dev=/dev/nvme0n1p1 at=/boot/efi [mounted]`,
+			`  /dev/nvme0n1p2 "Data" 999666221056 ntfs dev=/dev/nvme0n1p2`,
+			`/dev/zram0 "zram0" 8589934592 disk`,
+		}},
+		{"lsblk-luks-lvm.json", []string{
+			`/dev/sda "SanDisk SD8SB8U2" 256060514304 disk`,
+			`  /dev/sda1 "" 1073741824 ext4 dev=/dev/sda1 at=/boot [mounted]`,
+			`  /dev/sda2 "" 254985707520 crypto_LUKS dev=/dev/sda2 [container,encrypted]`,
+			`    /dev/mapper/luks-4c1a8f0e "" 254968930304 LVM2_member dev=/dev/mapper/luks-4c1a8f0e [container]`,
+			`      /dev/mapper/vg0-root "" 107374182400 ext4 dev=/dev/mapper/vg0-root at=/ [mounted]`,
+			`      /dev/mapper/vg0-home "home" 147594747904 ext4 dev=/dev/mapper/vg0-home at=/home [mounted]`,
+			`/dev/sdb "Ultra Fit" 31914983424 disk`,
+			`  /dev/sdb1 "" 31913934848 crypto_LUKS dev=/dev/sdb1 [locked]`,
+		}},
+		{"lsblk-whole-disk.json", []string{
+			`/dev/sdc "Flash Disk" 15728640000 disk`,
+			`  /dev/sdc "BACKUP" 15728640000 exfat dev=/dev/sdc at=/media/user/BACKUP [mounted]`,
+		}},
+		{"lsblk-loop.json", []string{
+			`/dev/loop0 "loop0" 58130432 loop`,
+			`  /dev/loop0 "" 58130432 squashfs dev=/dev/loop0 at=/snap/core18/2812 [mounted]`,
+			`/dev/loop2 "loop2" 4702208000 loop`,
+			`  /dev/loop2p1 "Ubuntu 24.04 LTS amd64" 4701159424 iso9660 dev=/dev/loop2p1 at=/media/user/Ubuntu 24.04 LTS amd64 [mounted]`,
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.fixture, func(t *testing.T) {
+			disks, err := linuxParseLsblk(readFixture(t, test.fixture))
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkLines(t, diskLines(disks), test.want)
+		})
+	}
+}
+
+func TestLinuxParseLsblkInvalid(t *testing.T) {
+	for _, output := range []string{"", "{", `{"blockdevices": [{"name": "sda", "size": "12x"}]}`} {
+		if _, err := linuxParseLsblk([]byte(output)); KindOf(err) != KindParseFailure {
+			t.Errorf("linuxParseLsblk(%q) = %v, want a parse failure", output, err)
+		}
+	}
+}
+
+func TestLinuxParseMountPoint(t *testing.T) {
+	tests := []struct {
+		output string
+		want   string
+	}{
+		{"Mounted /dev/sdb1 at /media/user/USB.\n", "/media/user/USB"},
+		{"Mounted /dev/sdb1 at /media/user/My Stick.", "/media/user/My Stick"},
+		{"Error mounting /dev/sdb1: wrong fs type", ""},
+	}
+	for _, test := range tests {
+		if got := linuxParseMountPoint(test.output); got != test.want {
+			t.Errorf("linuxParseMountPoint(%q) = %q, want %q", test.output, got, test.want)
+		}
+	}
+	if got := linuxParseCleartext("Unlocked /dev/sdb1 as /dev/dm-0.\n"); got != "/dev/dm-0" {
+		t.Errorf("linuxParseCleartext = %q, want /dev/dm-0", got)
+	}
+}
+
+func TestLinuxParseUdevEvent(t *testing.T) {
+	tests := []struct {
+		line string
+		want DeviceEvent
+		ok   bool
+	}{
+		{"UDEV  [4123.586120] add      /devices/pci0000:00/0000:00:14.0/usb2/2-1/2-1:1.0/host6/target6:0:0/6:0:0:0/block/sdb (block)", DeviceEvent{DeviceAdded, "/dev/sdb"}, true},
+		{"UDEV  [4123.652043] add      /devices/pci0000:00/0000:00:14.0/usb2/2-1/2-1:1.0/host6/target6:0:0/6:0:0:0/block/sdb/sdb1 (block)", DeviceEvent{DeviceAdded, "/dev/sdb1"}, true},
+		{"UDEV  [4170.010388] change   /devices/virtual/block/dm-0 (block)", DeviceEvent{DeviceChanged, "/dev/dm-0"}, true},
+		{"UDEV  [4201.447932] remove   /devices/pci0000:00/0000:00:14.0/usb2/2-1/2-1:1.0/host6/target6:0:0/6:0:0:0/block/sdb/sdb1 (block)", DeviceEvent{DeviceRemoved, "/dev/sdb1"}, true},
+		{"UDEV  [4123.600001] bind     /devices/pci0000:00/0000:00:14.0/usb2/2-1/2-1:1.0/host6/target6:0:0/6:0:0:0/block/sdb (block)", DeviceEvent{}, false},
+		{"monitor will print the received events for:", DeviceEvent{}, false},
+		{"UDEV - the event which udev sends out after rule processing", DeviceEvent{}, false},
+		{"", DeviceEvent{}, false},
+	}
+	for _, test := range tests {
+		if got, ok := linuxParseUdevEvent(test.line); got != test.want || ok != test.ok {
+			t.Errorf("linuxParseUdevEvent(%q) = %v, %t, want %v, %t", test.line, got, ok, test.want, test.ok)
+		}
+	}
+}
+
+func TestLinuxPlanScript(t *testing.T) {
+	const gib = 1 << 30
+	table := func(scheme string, thirdFilesystem string) PartitionTable {
+		return newPartitionTable(scheme, 64*gib, []TableEntry{
+			{Number: 1, Name: "EFI", Filesystem: "vfat", Offset: 1 << 20, Size: 100 << 20},
+			{Number: 2, Name: "home", Filesystem: "ext4", Offset: 101 << 20, Size: 8 * gib},
+			{Number: 3, Name: "Windows", Filesystem: thirdFilesystem, Offset: 101<<20 + 8*gib, Size: 16 * gib},
+		})
+	}
+	// the free space after partition 3
+	const free = 101<<20 + 24*gib
+	tests := []struct {
+		name   string
+		device string
+		table  PartitionTable
+		ops    func(plan *Plan) error
+		want   string
+	}{
+		{"create on GPT", "/dev/sda", table("GPT", "ntfs"), func(plan *Plan) error {
+			return plan.Create(free, 4*gib, FilesystemExt4, "Bob's")
+		}, `set -e
+'parted' '-s' '-a' 'none' '/dev/sda' 'unit' 'B' 'mkpart' 'Bob'\''s' 'ext4' '25875709952B' '30170677247B'
+'udevadm' 'settle'
+'mkfs.ext4' '-F' '-L' 'Bob'\''s' '/dev/sda4'`},
+		// MBR has no partition names, so parted gets the type instead
+		{"create on MBR", "/dev/nvme0n1", table("MBR", "ntfs"), func(plan *Plan) error {
+			return plan.Create(free, 4*gib, FilesystemFAT32, "stick")
+		}, `set -e
+'parted' '-s' '-a' 'none' '/dev/nvme0n1' 'unit' 'B' 'mkpart' 'primary' 'fat32' '25875709952B' '30170677247B'
+'udevadm' 'settle'
+'mkfs.vfat' '-F' '32' '-n' 'STICK' '/dev/nvme0n1p4'`},
+		{"create NTFS", "/dev/sda", table("GPT", "ntfs"), func(plan *Plan) error {
+			return plan.Create(free, 4*gib, FilesystemNTFS, "")
+		}, `set -e
+'parted' '-s' '-a' 'none' '/dev/sda' 'unit' 'B' 'mkpart' 'primary' 'ntfs' '25875709952B' '30170677247B'
+'udevadm' 'settle'
+'mkfs.ntfs' '-f' '/dev/sda4'`},
+		{"delete", "/dev/sda", table("GPT", "ntfs"), func(plan *Plan) error {
+			return plan.Delete(3)
+		}, `set -e
+'parted' '-s' '/dev/sda' 'rm' '3'`},
+		// ext is shrunk before its partition
+		{"shrink", "/dev/sda", table("GPT", "ntfs"), func(plan *Plan) error {
+			return plan.Resize(2, 4*gib)
+		}, `set -e
+'e2fsck' '-f' '-y' '/dev/sda2'
+'resize2fs' '/dev/sda2' '4194304K'
+'parted' '-s' '/dev/sda' 'unit' 'B' 'resizepart' '2' '4400873471B'`},
+		// and grown after it, once the kernel sees the new size
+		{"grow", "/dev/sda", table("GPT", "ntfs"), func(plan *Plan) error {
+			if err := plan.Delete(3); err != nil {
+				return err
+			}
+			return plan.Resize(2, 16*gib)
+		}, `set -e
+'parted' '-s' '/dev/sda' 'rm' '3'
+'parted' '-s' '/dev/sda' 'unit' 'B' 'resizepart' '2' '17285775359B'
+'udevadm' 'settle'
+'e2fsck' '-f' '-y' '/dev/sda2'
+'resize2fs' '/dev/sda2'`},
+		{"resize without filesystem", "/dev/sda", table("GPT", ""), func(plan *Plan) error {
+			return plan.Resize(3, 32*gib)
+		}, `set -e
+'parted' '-s' '/dev/sda' 'unit' 'B' 'resizepart' '3' '43055579135B'`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			plan := NewPlan(test.table)
+			if err := test.ops(plan); err != nil {
+				t.Fatal(err)
+			}
+			got, err := linuxPlanScript(test.device, test.table.Scheme, plan)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != test.want {
+				t.Errorf("linuxPlanScript = \n%s\nwant\n%s", got, test.want)
+			}
+		})
+	}
+}
+
+func TestLinuxCheckOperation(t *testing.T) {
+	const gib = 1 << 30
+	plan := NewPlan(newPartitionTable("GPT", 64*gib, []TableEntry{
+		{Number: 1, Name: "Windows", Filesystem: "ntfs", Offset: 1 << 20, Size: 16 * gib},
+		{Number: 2, Name: "home", Filesystem: "ext4", Offset: 1<<20 + 16*gib, Size: 16 * gib},
+	}))
+	plan.Check = linuxCheckOperation
+	if err := plan.Resize(1, 8*gib); err == nil || err.Error() != "Qartion can only resize ext filesystems, not ntfs" {
+		t.Errorf("resizing NTFS: got %v", err)
+	}
+	if len(plan.Operations) != 0 {
+		t.Errorf("the refused resize was queued: %v", plan.Operations)
+	}
+	if err := plan.Resize(2, 8*gib); err != nil {
+		t.Errorf("resizing ext4: %v", err)
+	}
+	// the script refuses what the editor would have
+	plan = NewPlan(plan.Base)
+	if err := plan.Resize(1, 8*gib); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := linuxPlanScript("/dev/sda", "GPT", plan); err == nil {
+		t.Error("linuxPlanScript resized NTFS")
+	}
+}
@@ -0,0 +1,28 @@
+package main
+
+import "github.com/therecipe/qt/core"
+
+var mainQueue = make(chan func(), 64)
+
+// runOnMain queues f to run on the Qt GUI thread. Widgets must only be
+// touched from that thread, so goroutines hand their results over this way.
+func runOnMain(f func()) {
+	mainQueue <- f
+}
+
+// startMainQueue drains the queue filled by runOnMain from a timer on the GUI
+// thread.
+func startMainQueue(parent core.QObject_ITF) {
+	timer := core.NewQTimer(parent)
+	timer.ConnectTimeout(func() {
+		for {
+			select {
+			case f := <-mainQueue:
+				f()
+			default:
+				return
+			}
+		}
+	})
+	timer.Start(50)
+}
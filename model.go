@@ -0,0 +1,260 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/therecipe/qt/core"
+	"github.com/therecipe/qt/gui"
+	orderedmap "github.com/wk8/go-ordered-map/v2"
+)
+
+// Columns of diskModel.
+const (
+	columnName = iota
+	columnDevice
+	columnFilesystem
+	columnLabel
+	columnUUID
+	columnSize
+	columnFree
+	columnMountPoint
+	columnFlags
+)
+
+var columnTitles = []string{"Name", "Device", "Filesystem", "Label", "UUID", "Size", "Free", "Mount Point", "Flags"}
+
+var (
+	// idRole holds the disk or partition ID on the first item of every row.
+	idRole = int(core.Qt__UserRole) + 1
+	// sortRole holds what sorting compares: sizes in bytes rather than their
+	// formatted text, and text without case.
+	sortRole = int(core.Qt__UserRole) + 2
+)
+
+// diskModel mirrors the disk listing in a QStandardItemModel, with disks at
+// the top level and their partitions as children, so any item view can show
+// it. Update only touches the rows that changed, which lets views keep their
+// selection, expansion and scroll position across reloads.
+type diskModel struct {
+	*gui.QStandardItemModel
+}
+
+func newDiskModel(parent core.QObject_ITF) *diskModel {
+	model := gui.NewQStandardItemModel(parent)
+	titles := make([]string, len(columnTitles))
+	for column, title := range columnTitles {
+		titles[column] = tr(title)
+	}
+	model.SetHorizontalHeaderLabels(titles)
+	return &diskModel{QStandardItemModel: model}
+}
+
+type modelRow struct {
+	id       string
+	columns  []modelCell
+	children []modelRow
+}
+
+type modelCell struct {
+	text string
+	// size is set for size columns, which sort by it instead of text
+	size uint64
+	// editable cells can be edited in the view, see newTreeView
+	editable bool
+}
+
+func textCell(text string) modelCell {
+	return modelCell{text: text}
+}
+
+func sizeCell(size uint64) modelCell {
+	if size == 0 {
+		return modelCell{}
+	}
+	return modelCell{text: formatSize(size), size: size}
+}
+
+func (c modelCell) sortValue() *core.QVariant {
+	if c.size > 0 {
+		return core.NewQVariant8(c.size)
+	}
+	return core.NewQVariant12(strings.ToLower(c.text))
+}
+
+func diskRow(disk Disk) modelRow {
+	columns := make([]modelCell, len(columnTitles))
+	columns[columnName] = textCell(disk.Name)
+	columns[columnDevice] = textCell(disk.Device)
+	columns[columnSize] = sizeCell(disk.Size)
+	return modelRow{
+		id:       disk.ID,
+		columns:  columns,
+		children: partitionRows(disk.Partitions),
+	}
+}
+
+// partitionFlags lists the states shown in the Flags column, in English.
+func partitionFlags(partition Partition) []string {
+	var flags []string
+	if partitionCount(partition.Partitions) > 0 {
+		flags = append(flags, "container")
+	}
+	if partition.MountPoint != "" {
+		flags = append(flags, "mounted")
+	}
+	if partition.ReadOnly {
+		flags = append(flags, "read-only")
+	}
+	if partition.Locked {
+		flags = append(flags, "locked")
+	} else if partition.Encrypted {
+		flags = append(flags, "encrypted")
+	}
+	return flags
+}
+
+func partitionRows(partitions *orderedmap.OrderedMap[string, Partition]) []modelRow {
+	if partitions == nil {
+		return nil
+	}
+	var rows []modelRow
+	for pair := partitions.Oldest(); pair != nil; pair = pair.Next() {
+		partition := pair.Value
+		columns := make([]modelCell, len(columnTitles))
+		columns[columnName] = textCell(partitionDisplayName(partition))
+		columns[columnDevice] = textCell(partition.Device)
+		columns[columnFilesystem] = textCell(partition.Filesystem)
+		columns[columnLabel] = modelCell{text: partition.Name, editable: renamable(partition)}
+		columns[columnUUID] = textCell(partition.UUID)
+		columns[columnSize] = sizeCell(partition.Size)
+		columns[columnFree] = sizeCell(partition.Free)
+		columns[columnMountPoint] = textCell(partition.MountPoint)
+		flags := partitionFlags(partition)
+		for i, flag := range flags {
+			flags[i] = tr(flag)
+		}
+		columns[columnFlags] = textCell(strings.Join(flags, ", "))
+		rows = append(rows, modelRow{
+			id:       partition.ID,
+			columns:  columns,
+			children: partitionRows(partition.Partitions),
+		})
+	}
+	return rows
+}
+
+func (m *diskModel) Update(disks *orderedmap.OrderedMap[string, Disk]) {
+	var rows []modelRow
+	for pair := disks.Oldest(); pair != nil; pair = pair.Next() {
+		rows = append(rows, diskRow(pair.Value))
+	}
+	syncRows(m.InvisibleRootItem(), rows)
+}
+
+func rowID(parent *gui.QStandardItem, row int) string {
+	return parent.Child(row, 0).Data(idRole).ToString()
+}
+
+// syncRows makes the children of parent match rows: rows whose ID is gone are
+// removed, rows that moved are taken and reinserted, new rows are inserted and
+// the text of existing rows is only set when it differs.
+func syncRows(parent *gui.QStandardItem, rows []modelRow) {
+	wanted := make(map[string]bool, len(rows))
+	for _, row := range rows {
+		wanted[row.id] = true
+	}
+	for i := parent.RowCount() - 1; i >= 0; i-- {
+		if !wanted[rowID(parent, i)] {
+			parent.RemoveRow(i)
+		}
+	}
+	for i, row := range rows {
+		if i >= parent.RowCount() || rowID(parent, i) != row.id {
+			items := newRowItems(row)
+			for j := i + 1; j < parent.RowCount(); j++ {
+				if rowID(parent, j) == row.id {
+					items = parent.TakeRow(j)
+					break
+				}
+			}
+			parent.InsertRow(i, items)
+		}
+		for column, cell := range row.columns {
+			item := parent.Child(i, column)
+			if item.Text() != cell.text || item.Data(sortRole).ToULongLong(nil) != cell.size {
+				item.SetText(cell.text)
+				item.SetData(cell.sortValue(), sortRole)
+			}
+			if item.IsEditable() != cell.editable {
+				item.SetEditable(cell.editable)
+			}
+		}
+		syncRows(parent.Child(i, 0), row.children)
+	}
+}
+
+func newRowItems(row modelRow) []*gui.QStandardItem {
+	items := make([]*gui.QStandardItem, len(row.columns))
+	for column := range row.columns {
+		items[column] = gui.NewQStandardItem()
+		items[column].SetEditable(row.columns[column].editable)
+		items[column].SetData(row.columns[column].sortValue(), sortRole)
+	}
+	items[0].SetData(core.NewQVariant12(row.id), idRole)
+	return items
+}
+
+// diskDiff lists the disks that appeared, went away or changed between two
+// listings.
+type diskDiff struct {
+	Added   []Disk
+	Removed []Disk
+	Changed []Disk
+}
+
+func diffDisks(old, new *orderedmap.OrderedMap[string, Disk]) diskDiff {
+	var diff diskDiff
+	for pair := old.Oldest(); pair != nil; pair = pair.Next() {
+		if _, ok := new.Get(pair.Key); !ok {
+			diff.Removed = append(diff.Removed, pair.Value)
+		}
+	}
+	for pair := new.Oldest(); pair != nil; pair = pair.Next() {
+		previous, ok := old.Get(pair.Key)
+		if !ok {
+			diff.Added = append(diff.Added, pair.Value)
+		} else if !diskEqual(previous, pair.Value) {
+			diff.Changed = append(diff.Changed, pair.Value)
+		}
+	}
+	return diff
+}
+
+func diskEqual(a, b Disk) bool {
+	return a.ID == b.ID && a.Name == b.Name && a.Size == b.Size && a.Type == b.Type && a.Device == b.Device && a.Image == b.Image &&
+		partitionsEqual(a.Partitions, b.Partitions)
+}
+
+func partitionsEqual(a, b *orderedmap.OrderedMap[string, Partition]) bool {
+	if a == nil || b == nil {
+		return partitionCount(a) == partitionCount(b)
+	}
+	if a.Len() != b.Len() {
+		return false
+	}
+	for pa, pb := a.Oldest(), b.Oldest(); pa != nil; pa, pb = pa.Next(), pb.Next() {
+		x, y := pa.Value, pb.Value
+		x.Partitions, y.Partitions = nil, nil
+		if x != y || !partitionsEqual(pa.Value.Partitions, pb.Value.Partitions) {
+			return false
+		}
+	}
+	return true
+}
+
+func partitionCount(partitions *orderedmap.OrderedMap[string, Partition]) int {
+	if partitions == nil {
+		return 0
+	}
+	return partitions.Len()
+}
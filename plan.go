@@ -0,0 +1,368 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+)
+
+// partitionAlignment is where the partitions a plan creates start and end,
+// as the platforms' own tools do.
+const partitionAlignment = 1 << 20
+
+// gptBackupSize is the size of the backup GPT at the end of a disk with
+// 512-byte sectors: 32 sectors of entries and the header.
+const gptBackupSize = 33 * 512
+
+// PartitionTable is the layout of a disk, as read by Backend.PartitionTable
+// and as a Plan changes it.
+type PartitionTable struct {
+	// Scheme is "GPT" or "MBR".
+	Scheme string
+	// Start and End bound the bytes partitions may use, leaving out the
+	// partition table itself.
+	Start uint64
+	End   uint64
+	// Entries are sorted by offset.
+	Entries []TableEntry
+}
+
+type TableEntry struct {
+	// Number is the partition's number in the table, which the backends
+	// address it by.
+	Number     int
+	Name       string
+	Filesystem string
+	Offset     uint64
+	Size       uint64
+}
+
+// newPartitionTable returns the table of a disk of size bytes. Partitions
+// start after the first partitionAlignment bytes, and end before the backup
+// table at the end of GPT disks. The usable area is widened to cover entries,
+// as partitions made by other tools may reach into what Qartion reserves.
+func newPartitionTable(scheme string, size uint64, entries []TableEntry) PartitionTable {
+	start, end := uint64(partitionAlignment), size
+	if scheme == "GPT" && end > gptBackupSize {
+		end -= gptBackupSize
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Offset < entries[j].Offset
+	})
+	for _, entry := range entries {
+		if entry.Offset < start {
+			start = entry.Offset
+		}
+		if entry.End() > end {
+			end = entry.End()
+		}
+	}
+	return PartitionTable{Scheme: scheme, Start: start, End: end, Entries: entries}
+}
+
+func (e TableEntry) End() uint64 {
+	return e.Offset + e.Size
+}
+
+// Region is either a partition or unallocated space.
+type Region struct {
+	Offset uint64
+	Size   uint64
+	// Entry is nil for unallocated space.
+	Entry *TableEntry
+}
+
+// Regions returns the partitions and the unallocated space between them in
+// disk order. Space too small to hold an aligned partition is left out.
+func (t PartitionTable) Regions() []Region {
+	var regions []Region
+	free := func(offset, end uint64) {
+		start := alignUp(offset)
+		if end > start && alignDown(end)-start >= partitionAlignment {
+			regions = append(regions, Region{Offset: offset, Size: end - offset})
+		}
+	}
+	offset := t.Start
+	for i := range t.Entries {
+		entry := &t.Entries[i]
+		if entry.Offset > offset {
+			free(offset, entry.Offset)
+		}
+		regions = append(regions, Region{Offset: entry.Offset, Size: entry.Size, Entry: entry})
+		if entry.End() > offset {
+			offset = entry.End()
+		}
+	}
+	if t.End > offset {
+		free(offset, t.End)
+	}
+	return regions
+}
+
+// maxNumber is the highest partition number the scheme has room for. MBR
+// disks are limited to primary partitions.
+func (t PartitionTable) maxNumber() int {
+	if t.Scheme == "MBR" {
+		return 4
+	}
+	return 128
+}
+
+// freeNumber returns the lowest unused partition number, which is the one
+// the tools pick for a new partition, or 0 if the table is full.
+func (t PartitionTable) freeNumber() int {
+	used := make(map[int]bool, len(t.Entries))
+	for _, entry := range t.Entries {
+		used[entry.Number] = true
+	}
+	for number := 1; number <= t.maxNumber(); number++ {
+		if !used[number] {
+			return number
+		}
+	}
+	return 0
+}
+
+func (t PartitionTable) find(number int) (int, bool) {
+	for i, entry := range t.Entries {
+		if entry.Number == number {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// entryAt returns the partition that starts at offset.
+func (t PartitionTable) entryAt(offset uint64) (TableEntry, bool) {
+	for _, entry := range t.Entries {
+		if entry.Offset == offset {
+			return entry, true
+		}
+	}
+	return TableEntry{}, false
+}
+
+// entryBefore returns the partition that ends last at or before offset.
+func (t PartitionTable) entryBefore(offset uint64) (TableEntry, bool) {
+	var before TableEntry
+	found := false
+	for _, entry := range t.Entries {
+		if entry.End() <= offset {
+			before, found = entry, true
+		}
+	}
+	return before, found
+}
+
+// entryAfter returns the partition that starts first at or after offset.
+func (t PartitionTable) entryAfter(offset uint64) (TableEntry, bool) {
+	for _, entry := range t.Entries {
+		if entry.Offset >= offset {
+			return entry, true
+		}
+	}
+	return TableEntry{}, false
+}
+
+func (t PartitionTable) clone() PartitionTable {
+	t.Entries = append([]TableEntry(nil), t.Entries...)
+	return t
+}
+
+var (
+	ErrPlanOverlap  = userErrorf("the partition would overlap another partition")
+	ErrPlanOutside  = userErrorf("the partition would not fit on the disk")
+	ErrPlanTooSmall = userErrorf("the partition would be smaller than 1 MiB")
+	ErrPlanFull     = userErrorf("the partition table has no room for another partition")
+	ErrPlanNotFound = userErrorf("no such partition")
+)
+
+type OperationKind int
+
+const (
+	OperationCreate OperationKind = iota
+	OperationDelete
+	OperationResize
+)
+
+// Operation is one change a Plan makes. Entry is the partition created,
+// deleted, or resized to Entry.Size.
+type Operation struct {
+	Kind  OperationKind
+	Entry TableEntry
+}
+
+// apply returns the table with op applied, or why op cannot be.
+func (t PartitionTable) apply(op Operation) (PartitionTable, error) {
+	t = t.clone()
+	entry := op.Entry
+	switch op.Kind {
+	case OperationCreate:
+		if entry.Number == 0 {
+			return t, ErrPlanFull
+		}
+		if _, ok := t.find(entry.Number); ok {
+			return t, fmt.Errorf("partition number %d is already in use", entry.Number)
+		}
+		t.Entries = append(t.Entries, entry)
+	case OperationDelete:
+		i, ok := t.find(entry.Number)
+		if !ok {
+			return t, ErrPlanNotFound
+		}
+		t.Entries = append(t.Entries[:i], t.Entries[i+1:]...)
+		return t, nil
+	case OperationResize:
+		i, ok := t.find(entry.Number)
+		if !ok {
+			return t, ErrPlanNotFound
+		}
+		t.Entries[i].Size = entry.Size
+		entry = t.Entries[i]
+	}
+	if entry.Size < partitionAlignment {
+		return t, ErrPlanTooSmall
+	}
+	if entry.Offset < t.Start || entry.End() > t.End {
+		return t, ErrPlanOutside
+	}
+	for _, other := range t.Entries {
+		if other.Number != entry.Number && other.Offset < entry.End() && entry.Offset < other.End() {
+			return t, ErrPlanOverlap
+		}
+	}
+	sort.Slice(t.Entries, func(i, j int) bool {
+		return t.Entries[i].Offset < t.Entries[j].Offset
+	})
+	return t, nil
+}
+
+// Plan queues changes to a partition table without touching the disk, so they
+// can be previewed and then applied in one go.
+type Plan struct {
+	Base       PartitionTable
+	Operations []Operation
+	// Check refuses operations the backend cannot carry out, when set.
+	Check func(table PartitionTable, op Operation) error
+}
+
+func NewPlan(table PartitionTable) *Plan {
+	return &Plan{Base: table}
+}
+
+// Table returns the partition table as it is once every operation is applied.
+// Operations are checked as they are added, so they still apply.
+func (p *Plan) Table() PartitionTable {
+	table := p.Base
+	for _, op := range p.Operations {
+		table, _ = table.apply(op)
+	}
+	return table
+}
+
+// PlanStep is an operation along with the table it is applied to.
+type PlanStep struct {
+	Operation Operation
+	Before    PartitionTable
+}
+
+// Steps returns the operations in order, each with the table as it is right
+// before it, which some backends need to address partitions.
+func (p *Plan) Steps() []PlanStep {
+	var steps []PlanStep
+	table := p.Base
+	for _, op := range p.Operations {
+		steps = append(steps, PlanStep{Operation: op, Before: table})
+		table, _ = table.apply(op)
+	}
+	return steps
+}
+
+func (p *Plan) add(op Operation) error {
+	table := p.Table()
+	if _, err := table.apply(op); err != nil {
+		return err
+	}
+	if p.Check != nil {
+		if err := p.Check(table, op); err != nil {
+			return err
+		}
+	}
+	p.Operations = append(p.Operations, op)
+	return nil
+}
+
+// Create adds a partition of size bytes at offset, rounded inwards to
+// partitionAlignment, with the lowest free number.
+func (p *Plan) Create(offset uint64, size uint64, filesystem string, label string) error {
+	table := p.Table()
+	start := alignUp(offset)
+	end := alignDown(offset + size)
+	if end < start {
+		end = start
+	}
+	return p.add(Operation{Kind: OperationCreate, Entry: TableEntry{
+		Number:     table.freeNumber(),
+		Name:       label,
+		Filesystem: filesystem,
+		Offset:     start,
+		Size:       end - start,
+	}})
+}
+
+func (p *Plan) Delete(number int) error {
+	table := p.Table()
+	i, ok := table.find(number)
+	if !ok {
+		return ErrPlanNotFound
+	}
+	return p.add(Operation{Kind: OperationDelete, Entry: table.Entries[i]})
+}
+
+// Resize moves the end of a partition so it is size bytes long, rounded down
+// so that it ends on partitionAlignment. The start stays where it is.
+func (p *Plan) Resize(number int, size uint64) error {
+	table := p.Table()
+	i, ok := table.find(number)
+	if !ok {
+		return ErrPlanNotFound
+	}
+	entry := table.Entries[i]
+	if end := alignDown(entry.Offset + size); end > entry.Offset {
+		size = end - entry.Offset
+	}
+	entry.Size = size
+	return p.add(Operation{Kind: OperationResize, Entry: entry})
+}
+
+// Undo drops the last operation.
+func (p *Plan) Undo() {
+	if len(p.Operations) > 0 {
+		p.Operations = p.Operations[:len(p.Operations)-1]
+	}
+}
+
+// MaxSize returns the largest size the partition can be resized to, up to
+// the next partition or the end of the usable area.
+func (t PartitionTable) MaxSize(number int) uint64 {
+	i, ok := t.find(number)
+	if !ok {
+		return 0
+	}
+	entry := t.Entries[i]
+	end := t.End
+	if i+1 < len(t.Entries) {
+		end = t.Entries[i+1].Offset
+	}
+	if alignDown(end) <= entry.End() {
+		return entry.Size
+	}
+	return alignDown(end) - entry.Offset
+}
+
+func alignUp(offset uint64) uint64 {
+	return (offset + partitionAlignment - 1) / partitionAlignment * partitionAlignment
+}
+
+func alignDown(offset uint64) uint64 {
+	return offset / partitionAlignment * partitionAlignment
+}
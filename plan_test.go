@@ -0,0 +1,335 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+const gibibyte = 1 << 30
+
+// testTable is a 16 GiB GPT disk with a 100 MiB EFI partition and 1 GiB of
+// data after it, and the rest free.
+func testTable() PartitionTable {
+	return newPartitionTable("GPT", 16*gibibyte, []TableEntry{
+		{Number: 2, Name: "Data", Filesystem: FilesystemExt4, Offset: 101 * mebibyte, Size: gibibyte},
+		{Number: 1, Name: "EFI", Filesystem: FilesystemFAT32, Offset: mebibyte, Size: 100 * mebibyte},
+	})
+}
+
+var (
+	testEFI  = TableEntry{Number: 1, Name: "EFI", Filesystem: FilesystemFAT32, Offset: mebibyte, Size: 100 * mebibyte}
+	testData = TableEntry{Number: 2, Name: "Data", Filesystem: FilesystemExt4, Offset: 101 * mebibyte, Size: gibibyte}
+)
+
+func TestNewPartitionTable(t *testing.T) {
+	tests := []struct {
+		name       string
+		table      PartitionTable
+		start, end uint64
+	}{
+		{"GPT", testTable(), mebibyte, 16*gibibyte - gptBackupSize},
+		{"MBR", newPartitionTable("MBR", 16*gibibyte, nil), mebibyte, 16 * gibibyte},
+		// partitions made by older tools start at sector 63
+		{"unaligned", newPartitionTable("MBR", 16*gibibyte, []TableEntry{{Number: 1, Offset: 63 * 512, Size: gibibyte}}), 63 * 512, 16 * gibibyte},
+		{"past the backup table", newPartitionTable("GPT", 16*gibibyte, []TableEntry{{Number: 1, Offset: mebibyte, Size: 16*gibibyte - mebibyte}}), mebibyte, 16 * gibibyte},
+	}
+	for _, test := range tests {
+		if test.table.Start != test.start || test.table.End != test.end {
+			t.Errorf("%s: Start, End = %d, %d, want %d, %d", test.name, test.table.Start, test.table.End, test.start, test.end)
+		}
+	}
+	if entries := testTable().Entries; entries[0].Number != 1 || entries[1].Number != 2 {
+		t.Errorf("entries are not sorted by offset: %v", entries)
+	}
+}
+
+func TestPlan(t *testing.T) {
+	full := newPartitionTable("MBR", 16*gibibyte, []TableEntry{
+		{Number: 1, Offset: mebibyte, Size: gibibyte},
+		{Number: 2, Offset: mebibyte + gibibyte, Size: gibibyte},
+		{Number: 3, Offset: mebibyte + 2*gibibyte, Size: gibibyte},
+		{Number: 4, Offset: mebibyte + 3*gibibyte, Size: gibibyte},
+	})
+	tests := []struct {
+		name string
+		base PartitionTable
+		// changes are made in order, up to the first that fails
+		changes []func(p *Plan) error
+		want    []TableEntry
+		err     error
+	}{
+		{
+			name: "create",
+			changes: []func(p *Plan) error{
+				func(p *Plan) error { return p.Create(1125*mebibyte, 2*gibibyte, FilesystemExFAT, "Media") },
+			},
+			want: []TableEntry{testEFI, testData, {Number: 3, Name: "Media", Filesystem: FilesystemExFAT, Offset: 1125 * mebibyte, Size: 2 * gibibyte}},
+		},
+		{
+			name: "create rounds inwards",
+			changes: []func(p *Plan) error{
+				func(p *Plan) error { return p.Create(1125*mebibyte+1, 2*gibibyte, FilesystemExFAT, "") },
+			},
+			want: []TableEntry{testEFI, testData, {Number: 3, Filesystem: FilesystemExFAT, Offset: 1126 * mebibyte, Size: 2*gibibyte - mebibyte}},
+		},
+		{
+			name: "create takes the lowest free number",
+			changes: []func(p *Plan) error{
+				func(p *Plan) error { return p.Delete(1) },
+				func(p *Plan) error { return p.Create(mebibyte, 50*mebibyte, FilesystemFAT32, "BOOT") },
+			},
+			want: []TableEntry{{Number: 1, Name: "BOOT", Filesystem: FilesystemFAT32, Offset: mebibyte, Size: 50 * mebibyte}, testData},
+		},
+		{
+			name: "delete",
+			changes: []func(p *Plan) error{
+				func(p *Plan) error { return p.Delete(2) },
+			},
+			want: []TableEntry{testEFI},
+		},
+		{
+			name: "resize rounds the end down",
+			changes: []func(p *Plan) error{
+				func(p *Plan) error { return p.Resize(2, 2*gibibyte+mebibyte-1) },
+			},
+			want: []TableEntry{testEFI, {Number: 2, Name: "Data", Filesystem: FilesystemExt4, Offset: 101 * mebibyte, Size: 2 * gibibyte}},
+		},
+		{
+			name: "shrink",
+			changes: []func(p *Plan) error{
+				func(p *Plan) error { return p.Resize(2, 512*mebibyte) },
+			},
+			want: []TableEntry{testEFI, {Number: 2, Name: "Data", Filesystem: FilesystemExt4, Offset: 101 * mebibyte, Size: 512 * mebibyte}},
+		},
+		{
+			name: "undo",
+			changes: []func(p *Plan) error{
+				func(p *Plan) error { return p.Delete(1) },
+				func(p *Plan) error { return p.Resize(2, 2*gibibyte) },
+				func(p *Plan) error { p.Undo(); return nil },
+			},
+			want: []TableEntry{testData},
+		},
+		{
+			name: "undo everything",
+			changes: []func(p *Plan) error{
+				func(p *Plan) error { return p.Delete(1) },
+				func(p *Plan) error { p.Undo(); p.Undo(); return nil },
+			},
+			want: []TableEntry{testEFI, testData},
+		},
+		{
+			name: "create over another partition",
+			changes: []func(p *Plan) error{
+				func(p *Plan) error { return p.Create(50*mebibyte, 100*mebibyte, FilesystemExFAT, "") },
+			},
+			err: ErrPlanOverlap,
+		},
+		{
+			name: "grow into the next partition",
+			changes: []func(p *Plan) error{
+				func(p *Plan) error { return p.Resize(1, 200*mebibyte) },
+			},
+			err: ErrPlanOverlap,
+		},
+		{
+			name: "create past the end",
+			changes: []func(p *Plan) error{
+				func(p *Plan) error { return p.Create(15*gibibyte, 2*gibibyte, FilesystemExFAT, "") },
+			},
+			err: ErrPlanOutside,
+		},
+		{
+			name: "create in the backup table",
+			changes: []func(p *Plan) error{
+				func(p *Plan) error { return p.Create(15*gibibyte, gibibyte, FilesystemExFAT, "") },
+			},
+			err: ErrPlanOutside,
+		},
+		{
+			name: "grow past the end",
+			changes: []func(p *Plan) error{
+				func(p *Plan) error { return p.Resize(2, 20*gibibyte) },
+			},
+			err: ErrPlanOutside,
+		},
+		{
+			name: "create less than the alignment",
+			changes: []func(p *Plan) error{
+				func(p *Plan) error { return p.Create(2000*mebibyte, mebibyte-1, FilesystemExFAT, "") },
+			},
+			err: ErrPlanTooSmall,
+		},
+		{
+			name: "shrink below the alignment",
+			changes: []func(p *Plan) error{
+				func(p *Plan) error { return p.Resize(2, mebibyte/2) },
+			},
+			err: ErrPlanTooSmall,
+		},
+		{
+			name: "create on a full MBR disk",
+			base: full,
+			changes: []func(p *Plan) error{
+				func(p *Plan) error { return p.Create(4*gibibyte+mebibyte, gibibyte, FilesystemExFAT, "") },
+			},
+			err: ErrPlanFull,
+		},
+		{
+			name: "delete a missing partition",
+			changes: []func(p *Plan) error{
+				func(p *Plan) error { return p.Delete(9) },
+			},
+			err: ErrPlanNotFound,
+		},
+		{
+			name: "delete twice",
+			changes: []func(p *Plan) error{
+				func(p *Plan) error { return p.Delete(2) },
+				func(p *Plan) error { return p.Delete(2) },
+			},
+			err: ErrPlanNotFound,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			base := test.base
+			if base.End == 0 {
+				base = testTable()
+			}
+			plan := NewPlan(base)
+			var err error
+			for _, change := range test.changes {
+				count := len(plan.Operations)
+				if err = change(plan); err != nil {
+					if len(plan.Operations) != count {
+						t.Errorf("a failed change was added to the plan")
+					}
+					break
+				}
+			}
+			if err != test.err {
+				t.Fatalf("err = %v, want %v", err, test.err)
+			}
+			if err == nil && !reflect.DeepEqual(plan.Table().Entries, test.want) {
+				t.Errorf("entries = %v, want %v", plan.Table().Entries, test.want)
+			}
+			if !reflect.DeepEqual(plan.Base, base) {
+				t.Errorf("the plan changed its base table")
+			}
+		})
+	}
+}
+
+func TestPlanSteps(t *testing.T) {
+	plan := NewPlan(testTable())
+	if err := plan.Delete(1); err != nil {
+		t.Fatal(err)
+	}
+	if err := plan.Resize(2, 2*gibibyte); err != nil {
+		t.Fatal(err)
+	}
+	steps := plan.Steps()
+	if len(steps) != 2 {
+		t.Fatalf("got %d steps, want 2", len(steps))
+	}
+	if got := steps[0].Before.Entries; !reflect.DeepEqual(got, []TableEntry{testEFI, testData}) {
+		t.Errorf("first step is applied to %v", got)
+	}
+	if got := steps[1].Before.Entries; !reflect.DeepEqual(got, []TableEntry{testData}) {
+		t.Errorf("second step is applied to %v", got)
+	}
+}
+
+func TestRegions(t *testing.T) {
+	tests := []struct {
+		name  string
+		table PartitionTable
+		want  [][2]uint64
+	}{
+		{"partitions and free space", testTable(), [][2]uint64{
+			{mebibyte, 100 * mebibyte},
+			{101 * mebibyte, gibibyte},
+			{1125 * mebibyte, 16*gibibyte - gptBackupSize - 1125*mebibyte},
+		}},
+		{"empty", newPartitionTable("MBR", 16*gibibyte, nil), [][2]uint64{
+			{mebibyte, 16*gibibyte - mebibyte},
+		}},
+		// a gap that cannot hold an aligned mebibyte is left out
+		{"small gaps", newPartitionTable("MBR", 16*gibibyte, []TableEntry{
+			{Number: 1, Offset: mebibyte, Size: gibibyte},
+			{Number: 2, Offset: mebibyte + gibibyte + mebibyte/2, Size: 16*gibibyte - gibibyte - 2*mebibyte},
+		}), [][2]uint64{
+			{mebibyte, gibibyte},
+			{mebibyte + gibibyte + mebibyte/2, 16*gibibyte - gibibyte - 2*mebibyte},
+		}},
+	}
+	for _, test := range tests {
+		var got [][2]uint64
+		for _, region := range test.table.Regions() {
+			got = append(got, [2]uint64{region.Offset, region.Size})
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: Regions = %v, want %v", test.name, got, test.want)
+		}
+	}
+	regions := testTable().Regions()
+	if regions[0].Entry == nil || regions[0].Entry.Number != 1 || regions[2].Entry != nil {
+		t.Errorf("Regions do not point at the partitions: %v", regions)
+	}
+}
+
+func TestMaxSize(t *testing.T) {
+	table := testTable()
+	tests := []struct {
+		number int
+		want   uint64
+	}{
+		// the next partition starts right after it
+		{1, 100 * mebibyte},
+		// up to the last whole mebibyte before the backup table
+		{2, 16*gibibyte - mebibyte - 101*mebibyte},
+		{9, 0},
+	}
+	for _, test := range tests {
+		if got := table.MaxSize(test.number); got != test.want {
+			t.Errorf("MaxSize(%d) = %d, want %d", test.number, got, test.want)
+		}
+	}
+}
+
+func TestFreeNumber(t *testing.T) {
+	tests := []struct {
+		name  string
+		table PartitionTable
+		want  int
+	}{
+		{"empty", newPartitionTable("GPT", 16*gibibyte, nil), 1},
+		{"gap", newPartitionTable("GPT", 16*gibibyte, []TableEntry{{Number: 1}, {Number: 2}, {Number: 4}}), 3},
+		{"after the last", testTable(), 3},
+		{"full MBR", newPartitionTable("MBR", 16*gibibyte, []TableEntry{{Number: 1}, {Number: 2}, {Number: 3}, {Number: 4}}), 0},
+		{"MBR numbers past 4", newPartitionTable("MBR", 16*gibibyte, []TableEntry{{Number: 1}, {Number: 5}}), 2},
+	}
+	for _, test := range tests {
+		if got := test.table.freeNumber(); got != test.want {
+			t.Errorf("%s: freeNumber = %d, want %d", test.name, got, test.want)
+		}
+	}
+}
+
+func TestAlign(t *testing.T) {
+	tests := []struct {
+		offset, up, down uint64
+	}{
+		{0, 0, 0},
+		{1, mebibyte, 0},
+		{mebibyte, mebibyte, mebibyte},
+		{mebibyte + 1, 2 * mebibyte, mebibyte},
+		{63 * 512, mebibyte, 0},
+	}
+	for _, test := range tests {
+		if up, down := alignUp(test.offset), alignDown(test.offset); up != test.up || down != test.down {
+			t.Errorf("alignUp, alignDown(%d) = %d, %d, want %d, %d", test.offset, up, down, test.up, test.down)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/therecipe/qt/gui"
+	"github.com/therecipe/qt/widgets"
+)
+
+// showProperties reads everything the backend knows about the disk or
+// partition with the given ID in the background and shows it in a dialog.
+// showError reports a failure to read it.
+func showProperties(title string, id string, showError func(error)) {
+	runTask(trf("Reading properties of %s…", title), listTimeout, func(ctx context.Context) func() {
+		info, err := backend.Info(ctx, id)
+		return func() {
+			if err != nil {
+				showError(err)
+				return
+			}
+			showPropertiesDialog(title, info)
+		}
+	})
+}
+
+func showPropertiesDialog(title string, info map[string]interface{}) {
+	var (
+		dialog       = widgets.NewQDialog(window, 0)
+		layout       = widgets.NewQVBoxLayout2(dialog)
+		tree         = widgets.NewQTreeWidget(nil)
+		buttons      = widgets.NewQDialogButtonBox3(widgets.QDialogButtonBox__Close, nil)
+		copyButton   = buttons.AddButton2(tr("Copy"), widgets.QDialogButtonBox__ActionRole)
+		exportButton = buttons.AddButton2(tr("Export…"), widgets.QDialogButtonBox__ActionRole)
+	)
+	dialog.SetWindowTitle(trf("%s Properties", title))
+	dialog.Resize2(600, 500)
+	tree.SetHeaderLabels([]string{tr("Property"), tr("Value")})
+	tree.SetAlternatingRowColors(true)
+	for _, key := range sortedKeys(info) {
+		item := widgets.NewQTreeWidgetItem4(tree, []string{key, propertyText(info[key])}, 0)
+		addPropertyChildren(item, info[key])
+	}
+	tree.ResizeColumnToContents(0)
+	layout.AddWidget(tree, 1, 0)
+	layout.AddWidget(buttons, 0, 0)
+
+	copyButton.ConnectClicked(func(bool) {
+		text, err := propertiesJSON(info)
+		if err != nil {
+			widgets.QMessageBox_Warning(dialog, tr("Copy"), err.Error(), widgets.QMessageBox__Ok, widgets.QMessageBox__Ok)
+			return
+		}
+		gui.QGuiApplication_Clipboard().SetText(text, gui.QClipboard__Clipboard)
+	})
+	exportButton.ConnectClicked(func(bool) {
+		path := widgets.QFileDialog_GetSaveFileName(dialog, tr("Export Properties"), title+".json", "JSON (*.json)", "", 0)
+		if path == "" {
+			return
+		}
+		text, err := propertiesJSON(info)
+		if err == nil {
+			err = os.WriteFile(path, []byte(text), 0o644)
+		}
+		if err != nil {
+			widgets.QMessageBox_Warning(dialog, tr("Export"), err.Error(), widgets.QMessageBox__Ok, widgets.QMessageBox__Ok)
+		}
+	})
+	buttons.ConnectRejected(func() {
+		dialog.Reject()
+	})
+	dialog.Exec()
+	dialog.DeleteLater()
+}
+
+// propertyText formats a scalar value for the Value column. Dictionaries and
+// arrays are left empty, as their entries are shown as child rows.
+func propertyText(value interface{}) string {
+	switch value := value.(type) {
+	case nil:
+		return ""
+	case bool:
+		if value {
+			return tr("Yes")
+		}
+		return tr("No")
+	case map[string]interface{}, []interface{}:
+		return ""
+	case []byte:
+		return fmt.Sprintf("%x", value)
+	}
+	return fmt.Sprint(value)
+}
+
+func addPropertyChildren(parent *widgets.QTreeWidgetItem, value interface{}) {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		for _, key := range sortedKeys(value) {
+			item := widgets.NewQTreeWidgetItem7(parent, []string{key, propertyText(value[key])}, 0)
+			addPropertyChildren(item, value[key])
+		}
+	case []interface{}:
+		for index, element := range value {
+			item := widgets.NewQTreeWidgetItem7(parent, []string{fmt.Sprint(index), propertyText(element)}, 0)
+			addPropertyChildren(item, element)
+		}
+	}
+}
+
+func propertiesJSON(info map[string]interface{}) (string, error) {
+	var buffer bytes.Buffer
+	if err := cliWriteJSON(&buffer, info); err != nil {
+		return "", err
+	}
+	return buffer.String(), nil
+}
@@ -1,10 +1,11 @@
 package main
 
 import (
+	"context"
 	_ "embed"
-	"fmt"
 	"os"
-	"runtime"
+	"strings"
+	"time"
 
 	"github.com/therecipe/qt/core"
 	"github.com/therecipe/qt/gui"
@@ -13,176 +14,358 @@ import (
 )
 
 var Disks = orderedmap.New[string, Disk]()
-var Volumes = orderedmap.New[string, Partition]()
 var VolumeType = float64(0)
 var window *widgets.QMainWindow
 
 type Disk struct {
-	ID         string
-	Name       string
-	Size       uint64
-	Type       string
-	Partitions *orderedmap.OrderedMap[string, Partition]
+	ID         string                                    `json:"id"`
+	Name       string                                    `json:"name"`
+	Size       uint64                                    `json:"size"`
+	Type       string                                    `json:"type,omitempty"`
+	Device     string                                    `json:"device,omitempty"`
+	Partitions *orderedmap.OrderedMap[string, Partition] `json:"partitions"`
+	// Image is the disk image file the disk is attached from.
+	Image string `json:"image,omitempty"`
 }
 
 type Partition struct {
-	ID         string
-	Type       string
-	Name       string
-	Size       uint64
-	Device     string
-	Partitions *orderedmap.OrderedMap[string, Partition]
-	MountPoint string
+	ID         string                                    `json:"id"`
+	Type       string                                    `json:"type,omitempty"`
+	Name       string                                    `json:"name"`
+	Size       uint64                                    `json:"size"`
+	Device     string                                    `json:"device,omitempty"`
+	UUID       string                                    `json:"uuid,omitempty"`
+	Filesystem string                                    `json:"filesystem,omitempty"`
+	Partitions *orderedmap.OrderedMap[string, Partition] `json:"partitions,omitempty"`
+	MountPoint string                                    `json:"mountPoint,omitempty"`
+	// Free and Used are the bytes free and in use on the filesystem, when
+	// the backend can tell, which is mostly when the volume is mounted.
+	Free     uint64 `json:"free,omitempty"`
+	Used     uint64 `json:"used,omitempty"`
+	ReadOnly bool   `json:"readOnly,omitempty"`
+	// Locked is only set on encrypted partitions whose contents cannot be
+	// read until they are unlocked.
+	Encrypted bool `json:"encrypted,omitempty"`
+	Locked    bool `json:"locked,omitempty"`
 }
+
+// Volumes returns the partitions of the disk that hold a filesystem rather
+// than other partitions, such as the volumes of an APFS container instead of
+// the container itself.
+func (d Disk) Volumes() []Partition {
+	return volumes(d.Partitions)
+}
+
+func volumes(partitions *orderedmap.OrderedMap[string, Partition]) []Partition {
+	var result []Partition
+	if partitions == nil {
+		return result
+	}
+	for pair := partitions.Oldest(); pair != nil; pair = pair.Next() {
+		if partitionCount(pair.Value.Partitions) > 0 {
+			result = append(result, volumes(pair.Value.Partitions)...)
+		} else {
+			result = append(result, pair.Value)
+		}
+	}
+	return result
+}
+
+// setPartition replaces the partition with the same ID as partition, however
+// deeply it is nested.
+func setPartition(partitions *orderedmap.OrderedMap[string, Partition], partition Partition) bool {
+	if partitions == nil {
+		return false
+	}
+	for pair := partitions.Oldest(); pair != nil; pair = pair.Next() {
+		if pair.Key == partition.ID {
+			pair.Value = partition
+			return true
+		}
+		if setPartition(pair.Value.Partitions, partition) {
+			return true
+		}
+	}
+	return false
+}
+
+// findNestedPartition finds the partition with the given ID, however deeply
+// it is nested.
+func findNestedPartition(partitions *orderedmap.OrderedMap[string, Partition], id string) (Partition, bool) {
+	if partitions == nil {
+		return Partition{}, false
+	}
+	for pair := partitions.Oldest(); pair != nil; pair = pair.Next() {
+		if pair.Key == id {
+			return pair.Value, true
+		}
+		if partition, ok := findNestedPartition(pair.Value.Partitions, id); ok {
+			return partition, true
+		}
+	}
+	return Partition{}, false
+}
+
+// lockablePartition returns the unlocked encrypted partition that holds the
+// volume with the given ID, which is either the volume itself or a container
+// it is nested in.
+func lockablePartition(partitions *orderedmap.OrderedMap[string, Partition], id string) (Partition, bool) {
+	if partitions == nil {
+		return Partition{}, false
+	}
+	for pair := partitions.Oldest(); pair != nil; pair = pair.Next() {
+		_, nested := findNestedPartition(pair.Value.Partitions, id)
+		if pair.Key != id && !nested {
+			continue
+		}
+		if partition, ok := lockablePartition(pair.Value.Partitions, id); ok {
+			return partition, true
+		}
+		if pair.Value.Encrypted && !pair.Value.Locked {
+			return pair.Value, true
+		}
+		return Partition{}, false
+	}
+	return Partition{}, false
+}
+
 type Data struct {
 	Disk      Disk
 	Partition Partition
 }
 
-func parseSize(size uint64) string {
-	terabyte := size / 1e+12
-	if terabyte > 0 {
-		return fmt.Sprintf("%dTB", terabyte)
+// loadCancel cancels the load started by the previous LoadData, if it is
+// still running.
+var loadCancel context.CancelFunc
+
+// listBanner reports a failure to list the disks above the cards.
+var listBanner *errorBanner
+
+// watchError is why devices are not being watched. It stays on listBanner
+// after a successful listing, as the cards then only change on Reload.
+var watchError error
+
+// model holds the current listing for item views.
+var model *diskModel
+
+// LoadData lists the disks in the background and updates the cards once the
+// listing is done. A cancelled load leaves everything as it was.
+func LoadData(l *widgets.QGridLayout) {
+	if loadCancel != nil {
+		loadCancel()
 	}
-	gigabyte := size / 1e+9
-	if gigabyte > 0 {
-		return fmt.Sprintf("%dGB", gigabyte)
+	loadCancel = runTask(tr("Scanning…"), operationTimeout, func(ctx context.Context) func() {
+		disks, err := listDisks(ctx)
+		if KindOf(err) == KindCancelled {
+			return nil
+		}
+		if err != nil {
+			return func() {
+				listBanner.ShowError(err)
+			}
+		}
+		errs := autoMount(ctx, disks)
+		return func() {
+			if watchError != nil {
+				listBanner.ShowError(watchError)
+			} else {
+				listBanner.Hide()
+			}
+			applyDisks(l, disks, errs)
+		}
+	})
+}
+
+// applyDisks diffs disks against the listing shown so far and only adds,
+// updates or removes the cards of disks that changed. errs are shown on the
+// cards of the disks they are keyed by.
+func applyDisks(l *widgets.QGridLayout, disks *orderedmap.OrderedMap[string, Disk], errs map[string]error) {
+	diff := diffDisks(Disks, disks)
+	Disks = disks
+	model.Update(disks)
+	for _, disk := range diff.Removed {
+		card, ok := cards.Get(disk.ID)
+		if !ok {
+			continue
+		}
+		// the disk went away, so its volumes may be automounted again when
+		// it comes back
+		for _, partition := range disk.Volumes() {
+			forgetAutoMount(partition)
+		}
+		l.RemoveWidget(card)
+		cards.Delete(disk.ID)
+		card.DeleteLater()
 	}
-	megabyte := size / 1000000
-	if megabyte > 0 {
-		return fmt.Sprintf("%dMB", megabyte)
+	for _, disk := range diff.Changed {
+		if card, ok := cards.Get(disk.ID); ok {
+			card.Update(disk)
+		}
+	}
+	for _, disk := range diff.Added {
+		card := newDiskCard(l, disk)
+		l.AddWidget2(card, l.RowCount(), 0, 0)
+		cards.Set(disk.ID, card)
 	}
-	kilobyte := size / 1000
-	if kilobyte > 0 {
-		return fmt.Sprintf("%dKB", kilobyte)
+	for id, err := range errs {
+		if card, ok := cards.Get(id); ok {
+			card.showError(err)
+		}
 	}
-	return fmt.Sprintf("%dB", size)
 }
 
-func LoadData(l *widgets.QGridLayout) {
-	for l.Count() > 0 {
-		layoutItem := l.TakeAt(0)
-		if layoutItem != nil {
-			layoutItem.Widget().SetParent(nil)
-			layoutItem.Widget().DestroyQWidget()
-		}
-	}
-	switch runtime.GOOS {
-	case "darwin":
-		{
-			Disks = DarwinGetPartitions()
-		}
-	case "windows":
-		{
-			Disks, _ = WindowsGetDisks()
-		}
-	}
-	var index = 0
-	for pair := Disks.Oldest(); pair != nil; pair = pair.Next() {
-		disk := pair.Value
-
-		var (
-			card          = widgets.NewQGroupBox2("", nil)
-			diskFont      = gui.NewQFont()
-			partitionFont = gui.NewQFont()
-			diskName      = widgets.NewQLabel2(disk.Name, nil, 0)
-			diskSize      = widgets.NewQLabel2(parseSize(disk.Size), nil, 0)
-		)
-		diskFont.SetPointSize(25)
-		diskName.SetFont(diskFont)
-		diskSize.SetFont(diskFont)
-
-		partitionFont.SetPointSize(15)
-
-		var layout = widgets.NewQGridLayout2()
-		layout.AddWidget2(diskName, 0, 0, 0)
-		layout.AddWidget2(diskSize, 0, 100, 0)
-
-		var pindex = 1
-		for pair := disk.Partitions.Oldest(); pair != nil; pair = pair.Next() {
-			partition := pair.Value
-			if partition.Name == "" {
-				partition.Name = "(No Name)"
+// cardForDevice finds the card showing the disk or partition a hot-plug event
+// is about.
+func cardForDevice(device string) *diskCard {
+	for pair := cards.Oldest(); pair != nil; pair = pair.Next() {
+		disk := pair.Value.disk
+		if disk.Device == device {
+			return pair.Value
+		}
+		for _, partition := range disk.Volumes() {
+			if partition.Device == device {
+				return pair.Value
 			}
-			var (
-				partitionName = widgets.NewQLabel2(partition.Name, nil, 0)
-				partitionSize = widgets.NewQLabel2(parseSize(partition.Size), nil, 0)
-				mountButton   = widgets.NewQPushButton2("Mount", nil)
-			)
-			partitionName.SetFont(partitionFont)
-			partitionSize.SetFont(partitionFont)
-
-			layout.AddWidget2(partitionName, pindex, 0, 0)
-			layout.AddWidget2(partitionSize, pindex, 1, core.Qt__AlignRight)
-			layout.AddWidget2(mountButton, pindex, 2, core.Qt__AlignRight)
-
-			mountButton.ConnectClicked(func(bool) {
-				if partition.MountPoint != "" {
-					switch runtime.GOOS {
-					case "darwin":
-						{
-							DarwinOpenFolder(partition.MountPoint)
-						}
-					case "windows":
-						{
-							WindowsOpenFolder(partition.MountPoint)
-						}
-					}
-				} else {
-					switch runtime.GOOS {
-					case "darwin":
-						{
-							success, partition := DarwinMountPartition(partition)
-							if success {
-								mountButton.SetText(partition.MountPoint)
-							}
-						}
-					case "windows":
-						{
-							success, mountpoint := WindowsMountVolume(partition.ID)
-							if success {
-								mountButton.SetText(mountpoint)
-							}
-						}
+			if partition.MountPoint != "" && strings.TrimSuffix(partition.MountPoint, `\`) == strings.TrimSuffix(device, `\`) {
+				return pair.Value
+			}
+		}
+	}
+	return nil
+}
+
+// applyDeviceEvents lists the disks again after a batch of hot-plug events.
+// If that fails, the error is shown on the cards the events were about.
+func applyDeviceEvents(l *widgets.QGridLayout, events []DeviceEvent) {
+	var affected []*diskCard
+	seen := make(map[string]bool)
+	for _, event := range events {
+		card := cardForDevice(event.Device)
+		if card == nil || seen[card.disk.ID] {
+			continue
+		}
+		seen[card.disk.ID] = true
+		affected = append(affected, card)
+	}
+
+	runTask(tr("Scanning…"), operationTimeout, func(ctx context.Context) func() {
+		disks, err := listDisks(ctx)
+		if err != nil {
+			return func() {
+				for _, card := range affected {
+					if card.shown() {
+						card.showError(err)
 					}
 				}
-			})
-
-			if partition.MountPoint != "" {
-				mountButton.SetText(partition.MountPoint)
 			}
-			pindex += 1
 		}
-		card.SetLayout(layout)
-		l.AddWidget2(card, index, 0, 0)
-		index += 1
+		errs := autoMount(ctx, disks)
+		return func() {
+			applyDisks(l, disks, errs)
+		}
+	})
+}
+
+func partitionDisplayName(partition Partition) string {
+	if partition.Name == "" {
+		return tr("(No Name)")
 	}
+	return partition.Name
 }
 
 func main() {
+	if code, ok := runCLI(os.Args[1:], os.Stdout, os.Stderr); ok {
+		os.Exit(code)
+	}
 	app := widgets.NewQApplication(len(os.Args), os.Args)
-	core.QCoreApplication_SetOrganizationName("oqDev")
-	core.QCoreApplication_SetApplicationName("Qartion")
+	locale := core.QLocale_System().Name()
+	setLanguage(locale)
+	// Qt's own translations cover the standard dialog buttons
+	qtTranslator := core.NewQTranslator(app)
+	if qtTranslator.Load("qtbase_"+locale, core.QLibraryInfo_Location(core.QLibraryInfo__TranslationsPath), "", "") {
+		app.InstallTranslator(qtTranslator)
+	}
+	core.QCoreApplication_SetOrganizationName(organizationName)
+	core.QCoreApplication_SetApplicationName(applicationName)
 	core.QCoreApplication_SetApplicationVersion("1.3.0")
+	sizeFormat = appSettings().SizeFormat()
 	window = widgets.NewQMainWindow(nil, 0)
 
 	menuBar := window.MenuBar()
-	menu := menuBar.AddMenu2("App")
-	reloadButton := menu.AddAction("Reload")
+	menu := menuBar.AddMenu2(tr("App"))
+	reloadButton := menu.AddAction(tr("Reload"))
 	reloadShortcut := gui.NewQKeySequence2("Ctrl+R", gui.QKeySequence__NativeText)
 	reloadButton.SetShortcut(reloadShortcut)
+	attachButton := menu.AddAction(tr("Attach Disk Image…"))
+	preferencesButton := menu.AddAction(tr("Preferences…"))
+	preferencesButton.SetMenuRole(widgets.QAction__PreferencesRole)
+	preferencesButton.ConnectTriggered(func(checked bool) {
+		showPreferences(window, func() {
+			// redraw the sizes and usage bars with the new settings
+			model.Update(Disks)
+			for pair := cards.Oldest(); pair != nil; pair = pair.Next() {
+				pair.Value.Update(pair.Value.disk)
+			}
+		})
+	})
 
 	var layout = widgets.NewQGridLayout2()
+	var cardsWidget = widgets.NewQWidget(nil, 0)
+	cardsWidget.SetLayout(layout)
+	// the cards stay in the same scroll area across reloads, so the scroll
+	// position is kept
+	var scrollArea = widgets.NewQScrollArea(nil)
+	scrollArea.SetWidgetResizable(true)
+	scrollArea.SetWidget(cardsWidget)
+
+	model = newDiskModel(window)
+	views = widgets.NewQStackedWidget(nil)
+	views.AddWidget(scrollArea)
+	views.AddWidget(newTreeView(model))
+	addViewMenu(menuBar)
+
 	var centralWidget = widgets.NewQWidget(window, 0)
-	centralWidget.SetLayout(layout)
+	var centralLayout = widgets.NewQVBoxLayout2(centralWidget)
+	listBanner = newErrorBanner()
+	centralLayout.AddWidget(listBanner, 0, 0)
+	centralLayout.AddWidget(views, 1, 0)
 	window.SetCentralWidget(centralWidget)
 
 	reloadButton.ConnectTriggered(func(checked bool) {
 		LoadData(layout)
 	})
+	attachButton.ConnectTriggered(func(checked bool) {
+		attachImage(layout)
+	})
+
+	tasks = newTaskBar()
+	window.StatusBar().AddPermanentWidget(tasks, 0)
+	startMainQueue(window)
 
 	LoadData(layout)
 
+	watcher := backend.Watcher()
+	if events, err := watcher.Start(); err != nil {
+		watchError = err
+		listBanner.ShowError(err)
+	} else {
+		go func() {
+			for batch := range batchEvents(events, 500*time.Millisecond) {
+				batch := batch
+				runOnMain(func() {
+					applyDeviceEvents(layout, batch)
+				})
+			}
+			if err := watcher.Err(); err != nil {
+				runOnMain(func() {
+					watchError = err
+					listBanner.ShowError(err)
+				})
+			}
+		}()
+		app.ConnectAboutToQuit(watcher.Stop)
+	}
+
 	window.SetWindowTitle("Qartion")
 	window.Show()
 	app.Exec()
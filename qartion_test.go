@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	orderedmap "github.com/wk8/go-ordered-map/v2"
+)
+
+// readFixture returns a file from testdata.
+func readFixture(t *testing.T, name string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// diskLines flattens a listing into one line per disk and partition, with
+// partitions indented under their parent, so that tests can compare it.
+func diskLines(disks *orderedmap.OrderedMap[string, Disk]) []string {
+	var lines []string
+	for pair := disks.Oldest(); pair != nil; pair = pair.Next() {
+		disk := pair.Value
+		line := fmt.Sprintf("%s %q %d %s", disk.ID, disk.Name, disk.Size, disk.Type)
+		if disk.Image != "" {
+			line += " image=" + disk.Image
+		}
+		lines = append(lines, line)
+		lines = append(lines, partitionLines(disk.Partitions, "  ")...)
+	}
+	return lines
+}
+
+func partitionLines(partitions *orderedmap.OrderedMap[string, Partition], indent string) []string {
+	var lines []string
+	if partitions == nil {
+		return nil
+	}
+	for pair := partitions.Oldest(); pair != nil; pair = pair.Next() {
+		p := pair.Value
+		line := fmt.Sprintf("%s%s %q %d %s dev=%s", indent, p.ID, p.Name, p.Size, p.Filesystem, p.Device)
+		if p.MountPoint != "" {
+			line += " at=" + p.MountPoint
+		}
+		if flags := partitionFlags(p); len(flags) > 0 {
+			line += " [" + strings.Join(flags, ",") + "]"
+		}
+		lines = append(lines, line)
+		lines = append(lines, partitionLines(p.Partitions, indent+"  ")...)
+	}
+	return lines
+}
+
+func checkLines(t *testing.T, got []string, want []string) {
+	t.Helper()
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got\n\t%s\nwant\n\t%s", strings.Join(got, "\n\t"), strings.Join(want, "\n\t"))
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"unicode"
+	"unicode/utf16"
+)
+
+var ErrLabelEmpty = userErrorf("the label cannot be empty")
+
+// labelRule limits the labels a filesystem can hold.
+type labelRule struct {
+	filesystem string
+	// maxLength counts UTF-16 code units, or bytes if inBytes is set.
+	maxLength int
+	inBytes   bool
+	// upper is set for filesystems that only store upper case labels.
+	upper     bool
+	forbidden string
+}
+
+var (
+	fatLabels  = labelRule{filesystem: FilesystemFAT32, maxLength: 11, inBytes: true, upper: true, forbidden: `*?.,;:/\|+=<>[]"`}
+	ntfsLabels = labelRule{filesystem: FilesystemNTFS, maxLength: 32}
+	extLabels  = labelRule{filesystem: FilesystemExt4, maxLength: 16, inBytes: true}
+)
+
+// labelRules are keyed by the filesystem names the backends report, in lower
+// case: lsblk, diskutil and Get-Volume each have their own.
+var labelRules = map[string]labelRule{
+	"vfat":  fatLabels,
+	"msdos": fatLabels,
+	"fat":   fatLabels,
+	"fat16": fatLabels,
+	"fat32": fatLabels,
+	"ntfs":  ntfsLabels,
+	"exfat": {filesystem: FilesystemExFAT, maxLength: 15},
+	"ext2":  extLabels,
+	"ext3":  extLabels,
+	"ext4":  extLabels,
+	"hfs":   {filesystem: FilesystemHFS, maxLength: 255, forbidden: ":"},
+	"hfs+":  {filesystem: FilesystemHFS, maxLength: 255, forbidden: ":"},
+	"apfs":  {filesystem: FilesystemAPFS, maxLength: 255, inBytes: true, forbidden: ":"},
+}
+
+// validateLabel checks label against what filesystem can store and returns it
+// the way it will be stored.
+func validateLabel(filesystem string, label string) (string, error) {
+	rule, ok := labelRules[strings.ToLower(filesystem)]
+	if !ok {
+		return "", userErrorf("cannot rename %s volumes", filesystem)
+	}
+	if label == "" {
+		return "", ErrLabelEmpty
+	}
+	if rule.upper {
+		label = strings.ToUpper(label)
+	}
+	for _, r := range label {
+		if unicode.IsControl(r) || strings.ContainsRune(rule.forbidden, r) {
+			return "", userErrorf("%s labels cannot contain %q", rule.filesystem, r)
+		}
+	}
+	if rule.inBytes && len(label) > rule.maxLength {
+		return "", userErrorf("%s labels can be at most %d bytes long", rule.filesystem, rule.maxLength)
+	}
+	if !rule.inBytes && len(utf16.Encode([]rune(label))) > rule.maxLength {
+		return "", userErrorf("%s labels can be at most %d characters long", rule.filesystem, rule.maxLength)
+	}
+	return label, nil
+}
+
+// renamable reports whether the label of partition can be changed. macOS only
+// reports the filesystem of APFS volumes, so an unknown one is checked by the
+// backend instead.
+func renamable(partition Partition) bool {
+	if partition.Locked || partitionCount(partition.Partitions) > 0 {
+		return false
+	}
+	_, ok := labelRules[strings.ToLower(partition.Filesystem)]
+	return ok || partition.Filesystem == ""
+}
+
+// startRename swaps the label of the row for a field to type the new one in.
+func (row *partitionRow) startRename() {
+	row.renaming = true
+	row.nameEdit.SetText(row.partition.Name)
+	row.name.Hide()
+	row.nameEdit.Show()
+	row.nameEdit.SetFocus2()
+	row.nameEdit.SelectAll()
+}
+
+// finishRename puts the label of the row back, and renames the volume if keep
+// is set and the label changed. Hiding the field ends its editing again,
+// which renaming guards against.
+func (card *diskCard) finishRename(row *partitionRow, keep bool) {
+	if !row.renaming {
+		return
+	}
+	row.renaming = false
+	row.nameEdit.Hide()
+	row.name.Show()
+	if label := row.nameEdit.Text(); keep && label != row.partition.Name {
+		card.rename(row.partition, label)
+	}
+}
+
+// rename changes the label of partition, unless the label does not suit its
+// filesystem, in which case it reports false.
+func (card *diskCard) rename(partition Partition, label string) bool {
+	label = strings.TrimSpace(label)
+	if partition.Filesystem != "" {
+		var err error
+		if label, err = validateLabel(partition.Filesystem, label); err != nil {
+			card.showError(err)
+			return false
+		}
+	}
+	card.run(trf("Renaming %s…", partitionDisplayName(partition)), func(ctx context.Context) error {
+		return backend.Rename(ctx, partition, label)
+	})
+	return true
+}
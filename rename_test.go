@@ -0,0 +1,38 @@
+package main
+
+import "testing"
+
+// The format wizard validates labels by the filesystems the backends offer.
+func TestLabelRulesCoverFilesystems(t *testing.T) {
+	for _, filesystem := range []string{FilesystemAPFS, FilesystemHFS, FilesystemExFAT, FilesystemFAT32, FilesystemNTFS, FilesystemExt4} {
+		if _, err := validateLabel(filesystem, "Data"); err != nil {
+			t.Errorf("validateLabel(%q, %q) = %v", filesystem, "Data", err)
+		}
+	}
+}
+
+func TestValidateLabel(t *testing.T) {
+	tests := []struct {
+		filesystem string
+		label      string
+		want       string
+		ok         bool
+	}{
+		{FilesystemFAT32, "usb stick", "USB STICK", true},
+		{"vfat", "backup.2024", "", false},
+		{"msdos", "TWELVE CHARS", "", false},
+		{FilesystemExFAT, "Fifteen chars 1", "Fifteen chars 1", true},
+		{FilesystemExFAT, "Sixteen chars 12", "", false},
+		{FilesystemNTFS, "Daten/Archiv", "Daten/Archiv", true},
+		{FilesystemExt4, "größere Daten 1", "", false},
+		{FilesystemHFS, "Time: Machine", "", false},
+		{FilesystemAPFS, "", "", false},
+		{"swap", "Data", "", false},
+	}
+	for _, test := range tests {
+		got, err := validateLabel(test.filesystem, test.label)
+		if got != test.want || (err == nil) != test.ok {
+			t.Errorf("validateLabel(%q, %q) = %q, %v, want %q", test.filesystem, test.label, got, err, test.want)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"errors"
+
+	"github.com/therecipe/qt/gui"
+	"github.com/therecipe/qt/widgets"
+)
+
+// mountError is a failed mount, which keeps the partition so that a damaged
+// filesystem can be offered for repair.
+type mountError struct {
+	partition Partition
+	err       error
+}
+
+func (e *mountError) Error() string {
+	return e.err.Error()
+}
+
+func (e *mountError) Unwrap() error {
+	return e.err
+}
+
+// newCheckLog opens a window that the output of a check is appended to while
+// it runs. Closing the window only hides it, so lines can still arrive; it is
+// deleted once finish has been called and it is closed.
+func newCheckLog(title string) (appendLine func(string), finish func(string)) {
+	var (
+		dialog  = widgets.NewQDialog(window, 0)
+		layout  = widgets.NewQVBoxLayout2(dialog)
+		log     = widgets.NewQPlainTextEdit(nil)
+		buttons = widgets.NewQDialogButtonBox3(widgets.QDialogButtonBox__Close, nil)
+		done    = false
+	)
+	dialog.SetWindowTitle(title)
+	dialog.Resize2(640, 400)
+	log.SetReadOnly(true)
+	log.SetFont(gui.QFontDatabase_SystemFont(gui.QFontDatabase__FixedFont))
+	layout.AddWidget(log, 1, 0)
+	layout.AddWidget(buttons, 0, 0)
+	buttons.ConnectRejected(func() {
+		dialog.Reject()
+	})
+	dialog.ConnectFinished(func(int) {
+		if done {
+			dialog.DeleteLater()
+		}
+	})
+	dialog.Show()
+
+	appendLine = func(line string) {
+		log.AppendPlainText(line)
+	}
+	finish = func(result string) {
+		done = true
+		log.AppendPlainText("\n" + result)
+		if !dialog.IsVisible() {
+			dialog.DeleteLater()
+		}
+	}
+	return appendLine, finish
+}
+
+// check verifies the filesystem of partition, or repairs it, and shows what
+// the tool prints in a log window. A repair is never stopped halfway.
+func (card *diskCard) check(partition Partition, repair bool) {
+	title := partitionDisplayName(partition)
+	label, logTitle, result := trf("Verifying %s…", title), trf("Verify %s", title), tr("No problems were found.")
+	run, start := backend.Verify, card.run
+	if repair {
+		label, logTitle, result = trf("Repairing %s…", title), trf("Repair %s", title), tr("The volume was repaired.")
+		run, start = backend.Repair, card.runUninterrupted
+	}
+	appendLine, finish := newCheckLog(logTitle)
+	start(label, func(ctx context.Context) error {
+		err := run(ctx, partition, func(line string) {
+			runOnMain(func() {
+				appendLine(line)
+			})
+		})
+		// queued after the last line
+		runOnMain(func() {
+			if err != nil {
+				finish(errorText(err))
+				return
+			}
+			finish(result)
+		})
+		return err
+	})
+}
+
+// offerRepair asks whether to repair the volume a mount failed on, when it
+// failed because its filesystem is damaged.
+func (card *diskCard) offerRepair(err error) {
+	var failed *mountError
+	if !errors.As(err, &failed) || KindOf(err) != KindCorrupted || isBootDisk(card.disk) {
+		return
+	}
+	text := trf("%s could not be mounted because its filesystem is damaged. Repair it now?", partitionDisplayName(failed.partition))
+	if widgets.QMessageBox_Question(window, tr("Repair Volume"), text, widgets.QMessageBox__Yes|widgets.QMessageBox__No, widgets.QMessageBox__Yes) == widgets.QMessageBox__Yes {
+		card.check(failed.partition, true)
+	}
+}
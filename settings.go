@@ -0,0 +1,309 @@
+package main
+
+import (
+	"context"
+	"net/url"
+	"runtime"
+	"strings"
+	"sync"
+
+	"github.com/therecipe/qt/core"
+	"github.com/therecipe/qt/widgets"
+	orderedmap "github.com/wk8/go-ordered-map/v2"
+)
+
+const (
+	organizationName = "oqDev"
+	applicationName  = "Qartion"
+)
+
+// VolumeSettings are the preferences remembered for a single volume.
+type VolumeSettings struct {
+	AutoMount      bool
+	OpenAfterMount bool
+	// MountPoint is either a drive letter such as "E:" or an empty folder.
+	MountPoint string
+	ReadOnly   bool
+}
+
+func (v VolumeSettings) MountOptions() MountOptions {
+	if windowsIsDriveRoot(v.MountPoint) {
+		return MountOptions{Letter: v.MountPoint, ReadOnly: v.ReadOnly}
+	}
+	return MountOptions{Path: v.MountPoint, ReadOnly: v.ReadOnly}
+}
+
+// Apply fills in what options leave open from the settings: the mount point
+// when none was chosen, and read-only when the volume is always mounted so.
+func (v VolumeSettings) Apply(options MountOptions) MountOptions {
+	saved := v.MountOptions()
+	if options.Path == "" && options.Letter == "" {
+		options.Path, options.Letter = saved.Path, saved.Letter
+	}
+	options.ReadOnly = options.ReadOnly || saved.ReadOnly
+	return options
+}
+
+// Settings persists preferences through QSettings, so they end up wherever
+// the platform keeps application settings (the registry on Windows, a plist
+// on macOS, an ini file under ~/.config on Linux). QSettings is part of
+// QtCore, so it also works from the command line without a QApplication.
+// The mutex lets background tasks share the store with the GUI thread.
+type Settings struct {
+	mu    sync.Mutex
+	store *core.QSettings
+}
+
+var (
+	settingsOnce sync.Once
+	settings     *Settings
+)
+
+// appSettings opens the settings store on first use rather than at package
+// initialisation, so commands that never touch settings never call into Qt.
+func appSettings() *Settings {
+	settingsOnce.Do(func() {
+		settings = &Settings{store: core.NewQSettings(organizationName, applicationName, nil)}
+	})
+	return settings
+}
+
+// volumeKey returns an identifier that survives reboots and replugging: the
+// filesystem UUID when the backend knows it, the partition ID otherwise.
+func volumeKey(partition Partition) string {
+	if partition.UUID != "" {
+		return partition.UUID
+	}
+	return partition.ID
+}
+
+func (s *Settings) volumeGroup(key string) string {
+	// QSettings treats both slashes as group separators
+	return "volumes/" + url.PathEscape(key)
+}
+
+func (s *Settings) Volume(key string) VolumeSettings {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.store.BeginGroup(s.volumeGroup(key))
+	defer s.store.EndGroup()
+	return VolumeSettings{
+		AutoMount:      s.store.Value("autoMount", core.NewQVariant9(false)).ToBool(),
+		OpenAfterMount: s.store.Value("openAfterMount", core.NewQVariant9(true)).ToBool(),
+		MountPoint:     s.store.Value("mountPoint", core.NewQVariant12("")).ToString(),
+		ReadOnly:       s.store.Value("readOnly", core.NewQVariant9(false)).ToBool(),
+	}
+}
+
+func (s *Settings) SetVolume(key string, volume VolumeSettings) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.store.BeginGroup(s.volumeGroup(key))
+	s.store.SetValue("autoMount", core.NewQVariant9(volume.AutoMount))
+	s.store.SetValue("openAfterMount", core.NewQVariant9(volume.OpenAfterMount))
+	s.store.SetValue("mountPoint", core.NewQVariant12(volume.MountPoint))
+	s.store.SetValue("readOnly", core.NewQVariant9(volume.ReadOnly))
+	s.store.EndGroup()
+	s.store.Sync()
+}
+
+// View returns the name of the view the window showed last, "cards" or
+// "tree".
+func (s *Settings) View() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.store.Value("view", core.NewQVariant12("cards")).ToString()
+}
+
+func (s *Settings) SetView(view string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.store.SetValue("view", core.NewQVariant12(view))
+	s.store.Sync()
+}
+
+// LowSpaceThreshold returns the percentage of free space below which a
+// volume's usage bar turns red.
+func (s *Settings) LowSpaceThreshold() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.store.Value("lowSpaceThreshold", core.NewQVariant5(10)).ToInt(nil)
+}
+
+func (s *Settings) SetLowSpaceThreshold(percent int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.store.SetValue("lowSpaceThreshold", core.NewQVariant5(percent))
+	s.store.Sync()
+}
+
+// SizeFormat returns the size format the user chose, with the separators of
+// the system locale. Windows defaults to binary units, like Explorer.
+func (s *Settings) SizeFormat() SizeFormat {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	units := "si"
+	if runtime.GOOS == "windows" {
+		units = "iec"
+	}
+	format := SizeFormat{Decimals: s.store.Value("sizeDecimals", core.NewQVariant5(1)).ToInt(nil)}
+	if s.store.Value("sizeUnits", core.NewQVariant12(units)).ToString() == "iec" {
+		format.Units = UnitsIEC
+	}
+	format.DecimalSeparator, format.GroupSeparator = localeSeparators(core.QLocale_System().Name())
+	return format
+}
+
+func (s *Settings) SetSizeFormat(format SizeFormat) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	units := "si"
+	if format.Units == UnitsIEC {
+		units = "iec"
+	}
+	s.store.SetValue("sizeUnits", core.NewQVariant12(units))
+	s.store.SetValue("sizeDecimals", core.NewQVariant5(format.Decimals))
+	s.store.Sync()
+}
+
+// autoMounted remembers the volumes autoMount already handled this session,
+// so a volume unmounted by hand is not mounted again on the next reload.
+var autoMounted = struct {
+	sync.Mutex
+	keys map[string]bool
+}{keys: map[string]bool{}}
+
+// forgetAutoMount lets autoMount handle a volume again, once it was unplugged.
+func forgetAutoMount(partition Partition) {
+	autoMounted.Lock()
+	defer autoMounted.Unlock()
+	delete(autoMounted.keys, volumeKey(partition))
+}
+
+// autoMount mounts every volume whose settings ask for it and returns the
+// failures keyed by disk ID.
+func autoMount(ctx context.Context, disks *orderedmap.OrderedMap[string, Disk]) map[string]error {
+	errs := make(map[string]error)
+	for pair := disks.Oldest(); pair != nil; pair = pair.Next() {
+		disk := pair.Value
+		for _, partition := range disk.Volumes() {
+			key := volumeKey(partition)
+			if partition.MountPoint != "" || partition.Locked {
+				continue
+			}
+			volume := appSettings().Volume(key)
+			if !volume.AutoMount {
+				continue
+			}
+			autoMounted.Lock()
+			handled := autoMounted.keys[key]
+			autoMounted.keys[key] = true
+			autoMounted.Unlock()
+			if handled {
+				continue
+			}
+			mounted, err := backend.Mount(ctx, partition, volume.MountOptions())
+			if err != nil {
+				errs[disk.ID] = &mountError{partition: partition, err: err}
+				continue
+			}
+			setPartition(disk.Partitions, mounted)
+			if volume.OpenAfterMount {
+				backend.Open(mounted.MountPoint)
+			}
+		}
+	}
+	return errs
+}
+
+func showVolumeSettings(parent widgets.QWidget_ITF, partition Partition) {
+	var (
+		key            = volumeKey(partition)
+		volume         = appSettings().Volume(key)
+		dialog         = widgets.NewQDialog(parent, 0)
+		layout         = widgets.NewQFormLayout(dialog)
+		autoMountBox   = widgets.NewQCheckBox2(tr("Mount automatically when Qartion starts"), nil)
+		openBox        = widgets.NewQCheckBox2(tr("Open the folder after mounting"), nil)
+		readOnlyBox    = widgets.NewQCheckBox2(tr("Always mount read-only"), nil)
+		mountPointEdit = widgets.NewQLineEdit2(volume.MountPoint, nil)
+		browseButton   = widgets.NewQPushButton2(tr("Browse…"), nil)
+		mountPointRow  = widgets.NewQHBoxLayout()
+		buttons        = widgets.NewQDialogButtonBox3(widgets.QDialogButtonBox__Save|widgets.QDialogButtonBox__Cancel, nil)
+	)
+	dialog.SetWindowTitle(partitionDisplayName(partition))
+	autoMountBox.SetChecked(volume.AutoMount)
+	openBox.SetChecked(volume.OpenAfterMount)
+	readOnlyBox.SetChecked(volume.ReadOnly)
+	mountPointEdit.SetPlaceholderText(tr("Chosen by the system"))
+	mountPointRow.AddWidget(mountPointEdit, 1, 0)
+	mountPointRow.AddWidget(browseButton, 0, 0)
+
+	layout.AddRow5(autoMountBox)
+	layout.AddRow5(openBox)
+	layout.AddRow5(readOnlyBox)
+	layout.AddRow4(tr("Mount point:"), mountPointRow)
+	layout.AddRow5(buttons)
+
+	browseButton.ConnectClicked(func(bool) {
+		path := widgets.QFileDialog_GetExistingDirectory(dialog, tr("Choose an empty folder"), mountPointEdit.Text(), widgets.QFileDialog__ShowDirsOnly)
+		if path != "" {
+			mountPointEdit.SetText(path)
+		}
+	})
+	buttons.ConnectAccepted(func() {
+		appSettings().SetVolume(key, VolumeSettings{
+			AutoMount:      autoMountBox.IsChecked(),
+			OpenAfterMount: openBox.IsChecked(),
+			MountPoint:     strings.TrimSpace(mountPointEdit.Text()),
+			ReadOnly:       readOnlyBox.IsChecked(),
+		})
+		dialog.Accept()
+	})
+	buttons.ConnectRejected(func() {
+		dialog.Reject()
+	})
+	dialog.Exec()
+	dialog.DeleteLater()
+}
+
+// showPreferences edits the settings that apply to every volume, and calls
+// saved once they were changed.
+func showPreferences(parent widgets.QWidget_ITF, saved func()) {
+	var (
+		dialog    = widgets.NewQDialog(parent, 0)
+		layout    = widgets.NewQFormLayout(dialog)
+		threshold = widgets.NewQSpinBox(nil)
+		units     = widgets.NewQComboBox(nil)
+		decimals  = widgets.NewQSpinBox(nil)
+		buttons   = widgets.NewQDialogButtonBox3(widgets.QDialogButtonBox__Save|widgets.QDialogButtonBox__Cancel, nil)
+		format    = appSettings().SizeFormat()
+	)
+	dialog.SetWindowTitle(tr("Preferences"))
+	threshold.SetRange(0, 50)
+	threshold.SetSuffix(" %")
+	threshold.SetValue(appSettings().LowSpaceThreshold())
+	units.AddItems([]string{tr("Decimal (kB, MB, GB)"), tr("Binary (KiB, MiB, GiB)")})
+	units.SetCurrentIndex(int(format.Units))
+	decimals.SetRange(0, 2)
+	decimals.SetValue(format.Decimals)
+
+	layout.AddRow3(tr("Warn when free space is below:"), threshold)
+	layout.AddRow3(tr("Size units:"), units)
+	layout.AddRow3(tr("Decimal places:"), decimals)
+	layout.AddRow5(buttons)
+
+	buttons.ConnectAccepted(func() {
+		appSettings().SetLowSpaceThreshold(threshold.Value())
+		format.Units = SizeUnits(units.CurrentIndex())
+		format.Decimals = decimals.Value()
+		appSettings().SetSizeFormat(format)
+		sizeFormat = format
+		dialog.Accept()
+		saved()
+	})
+	buttons.ConnectRejected(func() {
+		dialog.Reject()
+	})
+	dialog.Exec()
+	dialog.DeleteLater()
+}
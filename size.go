@@ -0,0 +1,109 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+)
+
+type SizeUnits int
+
+const (
+	// UnitsSI counts in powers of 1000 (kB, MB, GB), like macOS and most
+	// drive vendors.
+	UnitsSI SizeUnits = iota
+	// UnitsIEC counts in powers of 1024 (KiB, MiB, GiB), the sizes Windows
+	// Explorer shows.
+	UnitsIEC
+)
+
+var sizeUnitNames = map[SizeUnits][]string{
+	UnitsSI:  {"B", "kB", "MB", "GB", "TB", "PB", "EB"},
+	UnitsIEC: {"B", "KiB", "MiB", "GiB", "TiB", "PiB", "EiB"},
+}
+
+// SizeFormat describes how byte counts are shown.
+type SizeFormat struct {
+	Units    SizeUnits
+	Decimals int
+	// DecimalSeparator and GroupSeparator come from the locale, see
+	// localeSeparators.
+	DecimalSeparator string
+	GroupSeparator   string
+}
+
+var defaultSizeFormat = SizeFormat{Units: UnitsSI, Decimals: 1, DecimalSeparator: ".", GroupSeparator: ","}
+
+// sizeFormat is the format formatSize uses. The GUI and the command line set
+// it from the settings on start.
+var sizeFormat = defaultSizeFormat
+
+func formatSize(size uint64) string {
+	return sizeFormat.Format(size)
+}
+
+// Format rounds size to the largest unit it reaches, so 1.96 TB with one
+// decimal is "2.0 TB" rather than "1.9 TB" or "1960.0 GB". Plain bytes are
+// shown without decimals.
+func (f SizeFormat) Format(size uint64) string {
+	base := 1000.0
+	if f.Units == UnitsIEC {
+		base = 1024
+	}
+	names := sizeUnitNames[f.Units]
+	if float64(size) < base {
+		return f.group(strconv.FormatUint(size, 10)) + " " + names[0]
+	}
+	value := float64(size)
+	unit := 0
+	for value >= base && unit < len(names)-1 {
+		value /= base
+		unit++
+	}
+	text := strconv.FormatFloat(value, 'f', f.Decimals, 64)
+	if rounded, _ := strconv.ParseFloat(text, 64); rounded >= base && unit < len(names)-1 {
+		// 999.96 GB rounds up to the next unit rather than to 1000.0 GB
+		unit++
+		text = strconv.FormatFloat(rounded/base, 'f', f.Decimals, 64)
+	}
+	whole, fraction, _ := strings.Cut(text, ".")
+	text = f.group(whole)
+	if fraction != "" {
+		text += f.DecimalSeparator + fraction
+	}
+	return text + " " + names[unit]
+}
+
+// group inserts the group separator between every three digits.
+func (f SizeFormat) group(digits string) string {
+	if f.GroupSeparator == "" || len(digits) <= 3 {
+		return digits
+	}
+	var b strings.Builder
+	for i, digit := range digits {
+		if i > 0 && (len(digits)-i)%3 == 0 {
+			b.WriteString(f.GroupSeparator)
+		}
+		b.WriteRune(digit)
+	}
+	return b.String()
+}
+
+// localeSeparators returns the decimal and group separators of a locale name
+// such as "de_DE" or "fr-CA". Locales that are not listed use "." and ",".
+func localeSeparators(locale string) (decimal string, group string) {
+	language, region, _ := strings.Cut(strings.ReplaceAll(locale, "-", "_"), "_")
+	region, _, _ = strings.Cut(region, ".")
+	switch strings.ToLower(language) {
+	case "de":
+		if strings.EqualFold(region, "CH") {
+			return ".", "\u2019"
+		}
+		return ",", "."
+	case "es", "it", "pt", "nl", "id", "tr", "da", "el", "ro":
+		return ",", "."
+	case "fr", "ru", "uk", "pl", "cs", "sk", "sv", "fi", "nb", "nn", "no", "hu", "bg":
+		// a narrow no-break space, so numbers are not wrapped
+		return ",", "\u202f"
+	}
+	return ".", ","
+}
@@ -0,0 +1,71 @@
+package main
+
+import "testing"
+
+func TestSizeFormat(t *testing.T) {
+	var (
+		si   = SizeFormat{Units: UnitsSI, Decimals: 1, DecimalSeparator: ".", GroupSeparator: ","}
+		iec  = SizeFormat{Units: UnitsIEC, Decimals: 1, DecimalSeparator: ".", GroupSeparator: ","}
+		de   = SizeFormat{Units: UnitsIEC, Decimals: 2, DecimalSeparator: ",", GroupSeparator: "."}
+		fr   = SizeFormat{Units: UnitsIEC, Decimals: 1, DecimalSeparator: ",", GroupSeparator: "\u202f"}
+		deCH = SizeFormat{Units: UnitsIEC, Decimals: 1, DecimalSeparator: ".", GroupSeparator: "\u2019"}
+	)
+	tests := []struct {
+		name   string
+		format SizeFormat
+		size   uint64
+		want   string
+	}{
+		{"zero", si, 0, "0 B"},
+		{"below the base", si, 999, "999 B"},
+		{"below the IEC base", iec, 1023, "1,023 B"},
+		{"base", si, 1000, "1.0 kB"},
+		{"IEC base", iec, 1024, "1.0 KiB"},
+		{"rounds up", si, 1960000000000, "2.0 TB"},
+		{"rolls over", si, 999960000000, "1.0 TB"},
+		{"rolls over IEC", iec, 1024*1024 - 1, "1.0 MiB"},
+		{"no decimals", SizeFormat{Units: UnitsSI}, 1400000, "1 MB"},
+		{"no decimals rolls over", SizeFormat{Units: UnitsSI}, 999500, "1 MB"},
+		{"two decimals", SizeFormat{Units: UnitsSI, Decimals: 2, DecimalSeparator: "."}, 1234567, "1.23 MB"},
+		{"SI gigabyte", si, 1 << 30, "1.1 GB"},
+		{"IEC gigabyte", iec, 1 << 30, "1.0 GiB"},
+		{"largest unit", si, 1<<64 - 1, "18.4 EB"},
+		{"de", de, 1234567, "1,18 MiB"},
+		{"de bytes", de, 1023, "1.023 B"},
+		{"fr", fr, 1536, "1,5 KiB"},
+		{"fr bytes", fr, 1000, "1\u202f000 B"},
+		{"de_CH", deCH, 1536, "1.5 KiB"},
+		{"de_CH bytes", deCH, 1000, "1\u2019000 B"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := test.format.Format(test.size); got != test.want {
+				t.Errorf("Format(%d) = %q, want %q", test.size, got, test.want)
+			}
+		})
+	}
+}
+
+func TestLocaleSeparators(t *testing.T) {
+	tests := []struct {
+		locale  string
+		decimal string
+		group   string
+	}{
+		{"en_US", ".", ","},
+		{"", ".", ","},
+		{"C", ".", ","},
+		{"de_DE", ",", "."},
+		{"de_AT.UTF-8", ",", "."},
+		{"de_CH", ".", "\u2019"},
+		{"de-ch", ".", "\u2019"},
+		{"fr_FR", ",", "\u202f"},
+		{"fr-CA", ",", "\u202f"},
+		{"es_ES", ",", "."},
+	}
+	for _, test := range tests {
+		if decimal, group := localeSeparators(test.locale); decimal != test.decimal || group != test.group {
+			t.Errorf("localeSeparators(%q) = %q, %q, want %q, %q", test.locale, decimal, group, test.decimal, test.group)
+		}
+	}
+}
@@ -0,0 +1,35 @@
+package main
+
+import orderedmap "github.com/wk8/go-ordered-map/v2"
+
+// addMountState fills in whether every mounted volume is read-only, and its
+// free and used space when the backend did not already know them. APFS
+// volumes are listed with their own usage, which statfs would report for the
+// whole container instead.
+func addMountState(disks *orderedmap.OrderedMap[string, Disk]) {
+	for pair := disks.Oldest(); pair != nil; pair = pair.Next() {
+		for _, partition := range pair.Value.Volumes() {
+			if partition.MountPoint == "" {
+				continue
+			}
+			partition.ReadOnly = partition.ReadOnly || volumeReadOnly(partition.MountPoint)
+			if partition.Free+partition.Used == 0 {
+				if free, used, err := volumeSpace(partition.MountPoint); err == nil {
+					partition.Free = free
+					partition.Used = used
+				}
+			}
+			setPartition(pair.Value.Partitions, partition)
+		}
+	}
+}
+
+// lowOnSpace reports whether less than threshold percent of the volume is
+// free. Volumes whose usage is unknown are never low on space.
+func lowOnSpace(partition Partition, threshold int) bool {
+	total := partition.Free + partition.Used
+	if total == 0 {
+		return false
+	}
+	return partition.Free*100 < total*uint64(threshold)
+}
@@ -0,0 +1,11 @@
+//go:build !darwin && !windows && !linux
+
+package main
+
+func volumeSpace(path string) (free uint64, used uint64, err error) {
+	return 0, 0, ErrNotSupported
+}
+
+func volumeReadOnly(path string) bool {
+	return false
+}
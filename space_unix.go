@@ -0,0 +1,26 @@
+//go:build darwin || linux
+
+package main
+
+import "syscall"
+
+// volumeSpace returns the bytes available to unprivileged users and the bytes
+// in use on the filesystem mounted at path, as df counts them: the blocks
+// reserved for root are neither free nor used.
+func volumeSpace(path string) (free uint64, used uint64, err error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return 0, 0, err
+	}
+	return uint64(stat.Bavail) * uint64(stat.Bsize), (uint64(stat.Blocks) - uint64(stat.Bfree)) * uint64(stat.Bsize), nil
+}
+
+// volumeReadOnly reports whether the filesystem at path is mounted read-only.
+func volumeReadOnly(path string) bool {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return false
+	}
+	// MNT_RDONLY on macOS and ST_RDONLY on Linux are both 1
+	return uint64(stat.Flags)&1 != 0
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+var (
+	kernel32                 = syscall.NewLazyDLL("kernel32.dll")
+	procGetDiskFreeSpaceEx   = kernel32.NewProc("GetDiskFreeSpaceExW")
+	procGetVolumeInformation = kernel32.NewProc("GetVolumeInformationW")
+)
+
+// fileReadOnlyVolume is the FILE_READ_ONLY_VOLUME filesystem flag.
+const fileReadOnlyVolume = 0x80000
+
+// volumeSpace returns the bytes available to the current user and the bytes
+// in use on the volume mounted at path, which may be a drive root or a folder.
+// Space held back by quotas is neither free nor used.
+func volumeSpace(path string) (free uint64, used uint64, err error) {
+	name, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	var total, totalFree uint64
+	ok, _, err := procGetDiskFreeSpaceEx.Call(
+		uintptr(unsafe.Pointer(name)),
+		uintptr(unsafe.Pointer(&free)),
+		uintptr(unsafe.Pointer(&total)),
+		uintptr(unsafe.Pointer(&totalFree)),
+	)
+	if ok == 0 {
+		return 0, 0, err
+	}
+	return free, total - totalFree, nil
+}
+
+// volumeReadOnly reports whether the volume mounted at path is read-only.
+// GetVolumeInformation wants the root with a trailing backslash, which is how
+// mount points are listed.
+func volumeReadOnly(path string) bool {
+	name, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return false
+	}
+	var flags uint32
+	ok, _, _ := procGetVolumeInformation.Call(
+		uintptr(unsafe.Pointer(name)),
+		0, 0, 0, 0,
+		uintptr(unsafe.Pointer(&flags)),
+		0, 0,
+	)
+	return ok != 0 && flags&fileReadOnlyVolume != 0
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/therecipe/qt/widgets"
+	orderedmap "github.com/wk8/go-ordered-map/v2"
+)
+
+// Timeouts for the commands run in the background. Operations may wait for an
+// authorization prompt, so they get more time than a listing.
+const (
+	listTimeout      = 30 * time.Second
+	operationTimeout = 3 * time.Minute
+)
+
+type task struct {
+	label  string
+	cancel context.CancelFunc
+	// cancellable is unset for tasks that must not be stopped halfway.
+	cancellable bool
+}
+
+// taskBar is the busy indicator shown in the status bar while background
+// tasks run. Like every widget it is only used from the GUI thread.
+type taskBar struct {
+	*widgets.QWidget
+	label        *widgets.QLabel
+	cancelButton *widgets.QPushButton
+	running      *orderedmap.OrderedMap[int, task]
+	next         int
+}
+
+var tasks *taskBar
+
+func newTaskBar() *taskBar {
+	var (
+		widget       = widgets.NewQWidget(nil, 0)
+		layout       = widgets.NewQHBoxLayout2(widget)
+		progress     = widgets.NewQProgressBar(nil)
+		label        = widgets.NewQLabel2("", nil, 0)
+		cancelButton = widgets.NewQPushButton2(tr("Cancel"), nil)
+		bar          = &taskBar{QWidget: widget, label: label, cancelButton: cancelButton, running: orderedmap.New[int, task]()}
+	)
+	layout.SetContentsMargins(0, 0, 0, 0)
+	// an empty range makes the bar spin instead of showing a percentage
+	progress.SetRange(0, 0)
+	progress.SetMaximumWidth(100)
+	progress.SetTextVisible(false)
+	layout.AddWidget(progress, 0, 0)
+	layout.AddWidget(label, 0, 0)
+	layout.AddWidget(cancelButton, 0, 0)
+
+	cancelButton.ConnectClicked(func(bool) {
+		bar.CancelAll()
+	})
+	widget.Hide()
+	return bar
+}
+
+func (b *taskBar) add(label string, cancel context.CancelFunc, cancellable bool) int {
+	b.next++
+	b.running.Set(b.next, task{label: label, cancel: cancel, cancellable: cancellable})
+	b.update()
+	return b.next
+}
+
+func (b *taskBar) remove(id int) {
+	b.running.Delete(id)
+	b.update()
+}
+
+// CancelAll cancels every running task that can be cancelled, killing the
+// commands they wait on.
+func (b *taskBar) CancelAll() {
+	for pair := b.running.Oldest(); pair != nil; pair = pair.Next() {
+		if pair.Value.cancellable {
+			pair.Value.cancel()
+		}
+	}
+}
+
+func (b *taskBar) update() {
+	latest := b.running.Newest()
+	if latest == nil {
+		b.Hide()
+		return
+	}
+	cancellable := false
+	for pair := b.running.Oldest(); pair != nil; pair = pair.Next() {
+		cancellable = cancellable || pair.Value.cancellable
+	}
+	b.label.SetText(latest.Value.label)
+	b.cancelButton.SetEnabled(cancellable)
+	b.Show()
+}
+
+// runTask runs work on a goroutine, with a context that is cancelled after
+// timeout or from the Cancel button, and then runs the function work returns
+// on the GUI thread. It must be called from the GUI thread.
+func runTask(label string, timeout time.Duration, work func(ctx context.Context) func()) context.CancelFunc {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	startTask(label, ctx, cancel, true, work)
+	return cancel
+}
+
+// runUninterruptedTask is runTask for work that would leave a disk damaged if
+// it were stopped halfway, such as formatting it. The context has no deadline
+// and the Cancel button leaves the task alone.
+func runUninterruptedTask(label string, work func(ctx context.Context) func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	startTask(label, ctx, cancel, false, work)
+}
+
+func startTask(label string, ctx context.Context, cancel context.CancelFunc, cancellable bool, work func(ctx context.Context) func()) {
+	id := tasks.add(label, cancel, cancellable)
+	go func() {
+		done := work(ctx)
+		cancel()
+		runOnMain(func() {
+			tasks.remove(id)
+			if done != nil {
+				done()
+			}
+		})
+	}()
+}
+
+// listDisks lists the disks within listTimeout, however long the task it is
+// part of may run.
+func listDisks(ctx context.Context) (*orderedmap.OrderedMap[string, Disk], error) {
+	ctx, cancel := context.WithTimeout(ctx, listTimeout)
+	defer cancel()
+	return backend.ListDisks(ctx)
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"github.com/therecipe/qt/core"
+	"github.com/therecipe/qt/gui"
+	"github.com/therecipe/qt/widgets"
+)
+
+// Pages of the views stack, in the order of viewNames.
+const (
+	viewCards = iota
+	viewTree
+)
+
+// viewNames are the names the chosen view is saved under.
+var viewNames = []string{"cards", "tree"}
+
+// views switches between the cards and the tree.
+var views *widgets.QStackedWidget
+
+// newTreeView shows model as a Disk → Container → Volume tree. The tree sorts
+// through a proxy, so the model itself keeps the order of the listing.
+func newTreeView(model *diskModel) *widgets.QTreeView {
+	var (
+		proxy = core.NewQSortFilterProxyModel(model)
+		tree  = widgets.NewQTreeView(nil)
+	)
+	proxy.SetSourceModel(model)
+	proxy.SetSortRole(sortRole)
+	proxy.SetDynamicSortFilter(true)
+
+	tree.SetModel(proxy)
+	tree.SetSortingEnabled(true)
+	// -1 keeps the listing order until a column header is clicked
+	tree.SortByColumn(-1, core.Qt__AscendingOrder)
+	tree.SetUniformRowHeights(true)
+	tree.SetAlternatingRowColors(true)
+
+	// containers are expanded as they appear, after which the tree keeps
+	// whatever the user collapsed
+	proxy.ConnectRowsInserted(func(parent *core.QModelIndex, first int, last int) {
+		for row := first; row <= last; row++ {
+			tree.Expand(proxy.Index(row, 0, parent))
+		}
+	})
+
+	// sourceRowAt returns the disk of the model row at source, and its
+	// partition unless the row is the disk itself.
+	sourceRowAt := func(source *core.QModelIndex) (Disk, *Partition, bool) {
+		id := model.ItemFromIndex(source.Sibling(source.Row(), columnName)).Data(idRole).ToString()
+		if disk, ok := Disks.Get(id); ok {
+			return disk, nil, true
+		}
+		for pair := Disks.Oldest(); pair != nil; pair = pair.Next() {
+			if partition, ok := findNestedPartition(pair.Value.Partitions, id); ok {
+				return pair.Value, &partition, true
+			}
+		}
+		return Disk{}, nil, false
+	}
+	rowAt := func(index *core.QModelIndex) (Disk, *Partition, bool) {
+		if !index.IsValid() {
+			return Disk{}, nil, false
+		}
+		return sourceRowAt(proxy.MapToSource(index))
+	}
+
+	// labels are renamed in place with F2, a click on a selected row or
+	// Rename… from the menu, as double-clicking opens the volume. Updates from a listing match the
+	// partitions in Disks and so are not taken for edits.
+	tree.SetEditTriggers(widgets.QAbstractItemView__EditKeyPressed | widgets.QAbstractItemView__SelectedClicked)
+	model.ConnectItemChanged(func(item *gui.QStandardItem) {
+		if item.Column() != columnLabel {
+			return
+		}
+		disk, partition, ok := sourceRowAt(item.Index())
+		card, shown := cards.Get(disk.ID)
+		if !ok || !shown || partition == nil || item.Text() == partition.Name {
+			return
+		}
+		if !card.rename(*partition, item.Text()) {
+			item.SetText(partition.Name)
+		}
+	})
+
+	tree.ConnectDoubleClicked(func(index *core.QModelIndex) {
+		disk, partition, ok := rowAt(index)
+		card, shown := cards.Get(disk.ID)
+		if !ok || !shown || partition == nil || partition.MountPoint == "" {
+			return
+		}
+		card.open(partition.MountPoint)
+	})
+
+	tree.SetContextMenuPolicy(core.Qt__CustomContextMenu)
+	tree.ConnectCustomContextMenuRequested(func(pos *core.QPoint) {
+		index := tree.IndexAt(pos)
+		disk, partition, ok := rowAt(index)
+		card, shown := cards.Get(disk.ID)
+		if !ok || !shown {
+			return
+		}
+		menu := widgets.NewQMenu(tree)
+		switch {
+		case partition == nil:
+			card.addDiskActions(menu)
+		case partitionCount(partition.Partitions) > 0:
+			// containers cannot be mounted themselves
+			id, title := partition.ID, partitionDisplayName(*partition)
+			if partition.Encrypted && !partition.Locked {
+				menu.AddAction(tr("Lock")).ConnectTriggered(func(bool) {
+					card.lock(id)
+				})
+			}
+			menu.AddAction(tr("Properties…")).ConnectTriggered(func(bool) {
+				showProperties(title, id, card.showError)
+			})
+		default:
+			card.addPartitionActions(menu, *partition, func() {
+				tree.Edit(index.Sibling(index.Row(), columnLabel))
+			})
+		}
+		menu.Exec2(tree.Viewport().MapToGlobal(pos), nil)
+		menu.DeleteLater()
+	})
+	return tree
+}
+
+// addViewMenu adds a View menu switching the views stack between its pages,
+// and restores the view chosen last time.
+func addViewMenu(menuBar *widgets.QMenuBar) {
+	var (
+		menu    = menuBar.AddMenu2(tr("View"))
+		group   = widgets.NewQActionGroup(menu)
+		titles  = []string{tr("Cards"), tr("Tree")}
+		current = viewCards
+	)
+	for index, name := range viewNames {
+		if name == appSettings().View() {
+			current = index
+		}
+	}
+	views.SetCurrentIndex(current)
+	for index, title := range titles {
+		index := index
+		action := group.AddAction(menu.AddAction(title))
+		action.SetCheckable(true)
+		action.SetChecked(index == current)
+		action.ConnectTriggered(func(bool) {
+			views.SetCurrentIndex(index)
+			appSettings().SetView(viewNames[index])
+		})
+	}
+}
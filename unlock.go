@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+
+	"github.com/therecipe/qt/widgets"
+)
+
+// askPassphrase asks for the password or recovery key of partition. The text
+// is only kept for as long as the unlock runs.
+func askPassphrase(partition Partition) (string, bool) {
+	var (
+		dialog  = widgets.NewQDialog(window, 0)
+		layout  = widgets.NewQFormLayout(dialog)
+		edit    = widgets.NewQLineEdit(nil)
+		buttons = widgets.NewQDialogButtonBox3(widgets.QDialogButtonBox__Ok|widgets.QDialogButtonBox__Cancel, nil)
+	)
+	dialog.SetWindowTitle(trf("Unlock %s", partitionDisplayName(partition)))
+	edit.SetEchoMode(widgets.QLineEdit__Password)
+	buttons.Button(widgets.QDialogButtonBox__Ok).SetText(tr("Unlock"))
+	layout.AddRow3(tr("Password or recovery key:"), edit)
+	layout.AddRow5(buttons)
+	buttons.ConnectAccepted(func() {
+		dialog.Accept()
+	})
+	buttons.ConnectRejected(func() {
+		dialog.Reject()
+	})
+	accepted := dialog.Exec() == int(widgets.QDialog__Accepted)
+	passphrase := edit.Text()
+	dialog.DeleteLater()
+	return passphrase, accepted && passphrase != ""
+}
+
+func (card *diskCard) unlock(partition Partition) {
+	passphrase, ok := askPassphrase(partition)
+	if !ok {
+		return
+	}
+	card.run(trf("Unlocking %s…", partitionDisplayName(partition)), func(ctx context.Context) error {
+		return backend.Unlock(ctx, partition, passphrase)
+	})
+}
+
+// lock locks the encrypted partition holding the volume with the given ID.
+func (card *diskCard) lock(id string) {
+	partition, ok := lockablePartition(card.disk.Partitions, id)
+	if !ok {
+		return
+	}
+	card.run(trf("Locking %s…", partitionDisplayName(partition)), func(ctx context.Context) error {
+		return backend.Lock(ctx, partition)
+	})
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+type DeviceEventKind int
+
+const (
+	DeviceAdded DeviceEventKind = iota
+	DeviceRemoved
+	DeviceChanged
+)
+
+// DeviceEvent reports that a disk or volume appeared, went away or changed.
+// Device is the name the platform uses for it (disk4s1, /dev/sdb1, E:), which
+// is matched against Disk.Device, Partition.Device and Partition.MountPoint
+// to find the card to update.
+type DeviceEvent struct {
+	Kind   DeviceEventKind
+	Device string
+}
+
+// Watcher reports hot-plug events. The channel returned by Start is closed
+// once the watcher stops, either through Stop or because it failed, and Err
+// then reports the failure. It is nil after Stop.
+type Watcher interface {
+	Start() (<-chan DeviceEvent, error)
+	Stop()
+	Err() error
+}
+
+// errWatchExited is the failure of a monitoring command that exits on its
+// own without reporting an error.
+var errWatchExited = errors.New("exited unexpectedly")
+
+// commandWatcher runs a long-lived monitoring command, such as
+// `diskutil activity` or `udevadm monitor`, and turns every line it prints
+// into an event through parse.
+type commandWatcher struct {
+	name  string
+	args  []string
+	parse func(line string) (DeviceEvent, bool)
+
+	mu      sync.Mutex
+	cmd     *exec.Cmd
+	stopped bool
+	err     error
+}
+
+func newCommandWatcher(parse func(line string) (DeviceEvent, bool), name string, args ...string) *commandWatcher {
+	return &commandWatcher{name: name, args: args, parse: parse}
+}
+
+func (w *commandWatcher) Start() (<-chan DeviceEvent, error) {
+	cmd := exec.Command(w.name, w.args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, &CommandError{
+			Kind:    classifyError(err, ""),
+			Op:      "watch devices",
+			Command: cmd.String(),
+			Err:     err,
+		}
+	}
+	w.mu.Lock()
+	w.cmd = cmd
+	w.mu.Unlock()
+
+	events := make(chan DeviceEvent)
+	go func() {
+		defer close(events)
+		scanner := bufio.NewScanner(stdout)
+		for scanner.Scan() {
+			if event, ok := w.parse(scanner.Text()); ok {
+				events <- event
+			}
+		}
+		err := cmd.Wait()
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		if w.stopped {
+			return
+		}
+		if err == nil {
+			err = errWatchExited
+		}
+		w.err = &CommandError{
+			Kind:    classifyError(err, stderr.String()),
+			Op:      "watch devices",
+			Command: cmd.String(),
+			Stderr:  stderr.String(),
+			Err:     err,
+		}
+	}()
+	return events, nil
+}
+
+func (w *commandWatcher) Err() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.err
+}
+
+func (w *commandWatcher) Stop() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.stopped = true
+	if w.cmd != nil && w.cmd.Process != nil {
+		w.cmd.Process.Kill()
+	}
+}
+
+// FakeWatcher delivers whatever is passed to Emit.
+type FakeWatcher struct {
+	events chan DeviceEvent
+	once   sync.Once
+	err    error
+}
+
+func NewFakeWatcher() *FakeWatcher {
+	return &FakeWatcher{events: make(chan DeviceEvent, 16)}
+}
+
+func (w *FakeWatcher) Start() (<-chan DeviceEvent, error) {
+	return w.events, nil
+}
+
+func (w *FakeWatcher) Emit(event DeviceEvent) {
+	w.events <- event
+}
+
+func (w *FakeWatcher) Stop() {
+	w.once.Do(func() {
+		close(w.events)
+	})
+}
+
+// Fail stops the watcher as if its monitoring command had failed with err.
+func (w *FakeWatcher) Fail(err error) {
+	w.once.Do(func() {
+		w.err = err
+		close(w.events)
+	})
+}
+
+func (w *FakeWatcher) Err() error {
+	return w.err
+}
+
+// batchEvents groups events that arrive within window of each other, as
+// plugging in a single disk typically produces a burst of them.
+func batchEvents(events <-chan DeviceEvent, window time.Duration) <-chan []DeviceEvent {
+	batches := make(chan []DeviceEvent)
+	go func() {
+		defer close(batches)
+		for event := range events {
+			batch := []DeviceEvent{event}
+			timer := time.NewTimer(window)
+		collect:
+			for {
+				select {
+				case event, ok := <-events:
+					if !ok {
+						break collect
+					}
+					batch = append(batch, event)
+				case <-timer.C:
+					break collect
+				}
+			}
+			timer.Stop()
+			batches <- batch
+		}
+	}()
+	return batches
+}
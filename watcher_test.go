@@ -0,0 +1,144 @@
+package main
+
+import (
+	"errors"
+	"os/exec"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestBatchEvents(t *testing.T) {
+	watcher := NewFakeWatcher()
+	events, err := watcher.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	batches := batchEvents(events, 50*time.Millisecond)
+
+	burst := []DeviceEvent{
+		{Kind: DeviceAdded, Device: "/dev/sdb"},
+		{Kind: DeviceAdded, Device: "/dev/sdb1"},
+		{Kind: DeviceChanged, Device: "/dev/sdb1"},
+	}
+	for _, event := range burst {
+		watcher.Emit(event)
+	}
+	if batch := <-batches; !reflect.DeepEqual(batch, burst) {
+		t.Errorf("first batch = %v, want %v", batch, burst)
+	}
+
+	removed := DeviceEvent{Kind: DeviceRemoved, Device: "/dev/sdb1"}
+	watcher.Emit(removed)
+	watcher.Stop()
+	if batch := <-batches; !reflect.DeepEqual(batch, []DeviceEvent{removed}) {
+		t.Errorf("second batch = %v, want [%v]", batch, removed)
+	}
+	if batch, ok := <-batches; ok {
+		t.Errorf("got %v after Stop, want the batches to be closed", batch)
+	}
+	// stopping twice is harmless
+	watcher.Stop()
+}
+
+func TestBatchEventsWindow(t *testing.T) {
+	watcher := NewFakeWatcher()
+	events, _ := watcher.Start()
+	batches := batchEvents(events, 10*time.Millisecond)
+	defer watcher.Stop()
+
+	for _, device := range []string{"disk4", "disk5"} {
+		watcher.Emit(DeviceEvent{Kind: DeviceAdded, Device: device})
+		batch := <-batches
+		if len(batch) != 1 || batch[0].Device != device {
+			t.Errorf("batch = %v, want only %s", batch, device)
+		}
+	}
+}
+
+// parseAnyLine turns every line into an added device named after the line.
+func parseAnyLine(line string) (DeviceEvent, bool) {
+	return DeviceEvent{Kind: DeviceAdded, Device: line}, true
+}
+
+func TestCommandWatcherExit(t *testing.T) {
+	tests := []struct {
+		name   string
+		script string
+		err    error
+		stderr string
+	}{
+		{"failure", "echo sdb; echo 'udevadm: no such device' >&2; exit 3", nil, "udevadm: no such device\n"},
+		{"clean exit", "echo sdb", errWatchExited, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			watcher := newCommandWatcher(parseAnyLine, "sh", "-c", test.script)
+			events, err := watcher.Start()
+			if err != nil {
+				t.Fatal(err)
+			}
+			var devices []string
+			for event := range events {
+				devices = append(devices, event.Device)
+			}
+			if !reflect.DeepEqual(devices, []string{"sdb"}) {
+				t.Errorf("devices = %q, want [sdb]", devices)
+			}
+			var commandError *CommandError
+			if !errors.As(watcher.Err(), &commandError) {
+				t.Fatalf("Err() = %v, want a *CommandError", watcher.Err())
+			}
+			if commandError.Op != "watch devices" || commandError.Stderr != test.stderr {
+				t.Errorf("Err() = %+v, want watch devices with stderr %q", commandError, test.stderr)
+			}
+			if test.err != nil && !errors.Is(commandError, test.err) {
+				t.Errorf("Err() = %v, want %v", commandError, test.err)
+			}
+			var exitError *exec.ExitError
+			if test.err == nil && (!errors.As(commandError, &exitError) || exitError.ExitCode() != 3) {
+				t.Errorf("Err() = %v, want exit status 3", commandError)
+			}
+		})
+	}
+}
+
+func TestCommandWatcherStop(t *testing.T) {
+	watcher := newCommandWatcher(parseAnyLine, "sh", "-c", "echo sdb; exec sleep 10")
+	events, err := watcher.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event := <-events; event.Device != "sdb" {
+		t.Errorf("event = %v, want sdb", event)
+	}
+	watcher.Stop()
+	for event := range events {
+		t.Errorf("got %v after Stop", event)
+	}
+	if err := watcher.Err(); err != nil {
+		t.Errorf("Err() = %v after Stop, want nil", err)
+	}
+}
+
+func TestCommandWatcherNotFound(t *testing.T) {
+	watcher := newCommandWatcher(parseAnyLine, "qartion-no-such-command")
+	if _, err := watcher.Start(); KindOf(err) != KindCommandNotFound {
+		t.Errorf("Start() = %v, want %v", err, KindCommandNotFound)
+	}
+}
+
+func TestFakeWatcherFail(t *testing.T) {
+	watcher := NewFakeWatcher()
+	events, _ := watcher.Start()
+	failure := errors.New("gone")
+	watcher.Fail(failure)
+	if _, ok := <-events; ok {
+		t.Error("events still open after Fail")
+	}
+	if err := watcher.Err(); err != failure {
+		t.Errorf("Err() = %v, want %v", err, failure)
+	}
+	// a failed watcher can still be stopped
+	watcher.Stop()
+}
@@ -1,159 +1,465 @@
+//go:build windows
+
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
-	"math/rand"
 	"os/exec"
+	"path/filepath"
 	"strconv"
 	"strings"
-	"time"
-
-	"github.com/getlantern/elevate"
 
 	orderedmap "github.com/wk8/go-ordered-map/v2"
 )
 
-func WindowsOpenFolder(path string) {
-	cmd := exec.Command("explorer", path)
-	cmd.Run()
+// windowsBackend runs the Windows tools directly. Qartion asks to run as
+// administrator in manifest.xml, so the tools already have the rights they
+// need, and starting them directly rather than through elevate lets Qartion
+// read what they print and hand them standard input.
+type windowsBackend struct{}
+
+func (windowsBackend) ListDisks(ctx context.Context) (*orderedmap.OrderedMap[string, Disk], error) {
+	disks, err := WindowsGetDisks(ctx)
+	if err != nil {
+		return nil, err
+	}
+	addMountState(disks)
+	return disks, nil
 }
 
-func WindowsMountVolume(volumeId string) (bool, string) {
-	letter := windowsGenerateLetter()
-	cmd := elevate.Command("mountvol", letter, volumeId)
-	err := cmd.Run()
-	WindowsOpenFolder(letter)
-	return err == nil, letter
+func (windowsBackend) Open(path string) error {
+	// explorer.exe exits with status 1 even when the window opened fine, so
+	// only a failure to start it is reported
+	return exec.Command("explorer", path).Start()
 }
 
-func windowsGenerateLetter() string {
-	letter := windowsRandomLetter()
-	for pair := Volumes.Oldest(); pair != nil; pair = pair.Next() {
-		if pair.Value.MountPoint == letter {
-			letter = windowsRandomLetter()
-		}
+func (windowsBackend) Mount(ctx context.Context, partition Partition, options MountOptions) (Partition, error) {
+	if options.Path != "" {
+		return windowsMountFolder(ctx, partition, options.Path, options.ReadOnly)
 	}
-	return letter
+	used, err := windowsUsedLetters(ctx)
+	if err != nil {
+		return partition, err
+	}
+	letter, err := windowsAllocateLetter(used, options.Letter)
+	if err != nil {
+		return partition, err
+	}
+	if partition, err = windowsSetReadOnly(ctx, partition, options.ReadOnly); err != nil {
+		return partition, err
+	}
+	if _, err := runCommand(ctx, "mount "+partition.ID, exec.Command("mountvol", letter, partition.ID)); err != nil {
+		return partition, err
+	}
+	partition.MountPoint = letter
+	return partition, nil
 }
 
-func windowsRandomLetter() string {
-	rand.Seed(time.Now().UnixNano())
-	randomNum := rand.Intn(26) + 65
-	return fmt.Sprintf("%c:\\", rune(randomNum))
+// windowsMountFolder mounts a volume on an empty folder of an NTFS volume,
+// which mountvol supports next to drive letters.
+func windowsMountFolder(ctx context.Context, partition Partition, path string, readOnly bool) (Partition, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return partition, err
+	}
+	if err := validateMountFolder(path); err != nil {
+		return partition, err
+	}
+	op := "mount " + partition.ID
+	output, err := windowsPowershellCommand(ctx, op, fmt.Sprintf("(Get-Volume -FilePath '%s').FileSystem", windowsQuote(path)))
+	if err != nil {
+		return partition, err
+	}
+	if fs := strings.TrimSpace(output); !strings.EqualFold(fs, "NTFS") {
+		return partition, fmt.Errorf("cannot mount on %s: the folder is on a %s volume, but only NTFS supports folder mount points", path, fs)
+	}
+	// mountvol expects the folder with a trailing backslash
+	if !strings.HasSuffix(path, `\`) {
+		path += `\`
+	}
+	if partition, err = windowsSetReadOnly(ctx, partition, readOnly); err != nil {
+		return partition, err
+	}
+	if _, err := runCommand(ctx, op, exec.Command("mountvol", path, partition.ID)); err != nil {
+		return partition, err
+	}
+	partition.MountPoint = path
+	return partition, nil
+}
+
+// windowsSetReadOnly sets the read-only attribute of the partition holding
+// the volume before it is mounted. Windows keeps the attribute across mounts,
+// so it is also cleared again when a read-only volume is mounted normally.
+func windowsSetReadOnly(ctx context.Context, partition Partition, readOnly bool) (Partition, error) {
+	if partition.ReadOnly == readOnly {
+		return partition, nil
+	}
+	script := fmt.Sprintf("$ErrorActionPreference = 'Stop'; Get-Partition | Where-Object { $_.AccessPaths -contains '%s' } | Set-Partition -IsReadOnly $%t",
+		windowsQuote(partition.ID), readOnly)
+	if _, err := runCommand(ctx, "mount "+partition.ID, exec.Command("powershell.exe", "-NoProfile", "-Command", script)); err != nil {
+		return partition, err
+	}
+	partition.ReadOnly = readOnly
+	return partition, nil
 }
 
-func windowsCommand(command string) (string, error) {
-	cmd := exec.Command("cmd.exe", "/C", command)
-	output, err := cmd.Output()
-	return string(output), err
+func (windowsBackend) Unmount(ctx context.Context, partition Partition) (Partition, error) {
+	// a drive letter is dismounted and taken offline with /P, while a folder
+	// mount point only needs its junction removed with /D
+	flag := "/D"
+	if windowsIsDriveRoot(partition.MountPoint) {
+		flag = "/P"
+	}
+	if _, err := runCommand(ctx, "unmount "+partition.MountPoint, exec.Command("mountvol", partition.MountPoint, flag)); err != nil {
+		return partition, err
+	}
+	partition.MountPoint = ""
+	return partition, nil
 }
 
-func windowsParseListDisk(output string) *orderedmap.OrderedMap[string, Disk] {
-	ls := strings.Split(output, "\n")
-	disks := orderedmap.New[string, Disk]()
-	for i, l := range ls {
-		if i == 0 {
+func (windowsBackend) Eject(ctx context.Context, disk Disk) error {
+	ejected := false
+	for _, partition := range disk.Volumes() {
+		mountPoint := partition.MountPoint
+		if !windowsIsDriveRoot(mountPoint) {
 			continue
 		}
-		vals := strings.Split(l, "  ")
-		values := make([]string, 0)
-		if len(vals) < 3 {
-			continue
+		command := fmt.Sprintf("(New-Object -ComObject Shell.Application).Namespace(17).ParseName('%s').InvokeVerb('Eject')", windowsQuote(mountPoint[:2]))
+		if _, err := windowsPowershellCommand(ctx, "eject "+mountPoint, command); err != nil {
+			return err
 		}
-		for _, r := range vals {
-			if strings.TrimSpace(r) != "" {
-				values = append(values, strings.TrimSpace(r))
-			}
+		ejected = true
+	}
+	if !ejected {
+		return fmt.Errorf("%s has no mounted volumes to eject", disk.Name)
+	}
+	return nil
+}
+
+// windowsUnlockScript reads the password from standard input, so that it
+// never shows up on a command line.
+const windowsUnlockScript = `$ErrorActionPreference = 'Stop'
+$secret = [Console]::In.ReadToEnd()
+if ('%[2]s' -eq 'RecoveryPassword') {
+	Unlock-BitLocker -MountPoint '%[1]s' -RecoveryPassword $secret | Out-Null
+} else {
+	Unlock-BitLocker -MountPoint '%[1]s' -Password (ConvertTo-SecureString $secret -AsPlainText -Force) | Out-Null
+}`
+
+func (windowsBackend) Unlock(ctx context.Context, partition Partition, passphrase string) error {
+	kind := "Password"
+	if windowsRecoveryKey.MatchString(strings.TrimSpace(passphrase)) {
+		kind = "RecoveryPassword"
+		passphrase = strings.TrimSpace(passphrase)
+	}
+	cmd := exec.Command("powershell.exe", "-NoProfile", "-Command", fmt.Sprintf(windowsUnlockScript, windowsQuote(partition.ID), kind))
+	cmd.Stdin = strings.NewReader(passphrase)
+	_, err := runCommand(ctx, "unlock "+partition.ID, cmd)
+	return err
+}
+
+func (windowsBackend) Lock(ctx context.Context, partition Partition) error {
+	script := fmt.Sprintf("$ErrorActionPreference = 'Stop'; Lock-BitLocker -MountPoint '%s' -ForceDismount | Out-Null", windowsQuote(partition.ID))
+	_, err := runCommand(ctx, "lock "+partition.ID, exec.Command("powershell.exe", "-NoProfile", "-Command", script))
+	return err
+}
+
+func (windowsBackend) Filesystems() []string {
+	return []string{FilesystemNTFS, FilesystemExFAT, FilesystemFAT32}
+}
+
+// windowsFormatDiskScript clears the disk unless it is still uninitialized,
+// initializes it with the chosen scheme and formats one partition spanning it.
+const windowsFormatDiskScript = `$ErrorActionPreference = 'Stop'
+$disk = Get-Disk -Number %[1]s
+if ($disk.PartitionStyle -ne 'RAW') { $disk | Clear-Disk -RemoveData -RemoveOEM -Confirm:$false }
+Initialize-Disk -Number %[1]s -PartitionStyle %[2]s
+New-Partition -DiskNumber %[1]s -UseMaximumSize -AssignDriveLetter | Format-Volume -FileSystem %[3]s -NewFileSystemLabel '%[4]s' -Confirm:$false | Out-Null`
+
+func (windowsBackend) FormatDisk(ctx context.Context, disk Disk, options FormatOptions) error {
+	if _, err := strconv.Atoi(disk.ID); err != nil {
+		return fmt.Errorf("cannot erase %s: it is not a partitioned disk", disk.Name)
+	}
+	script := fmt.Sprintf(windowsFormatDiskScript, disk.ID, options.Scheme, options.Filesystem, windowsQuote(options.Label))
+	_, err := runCommand(ctx, "erase "+disk.Device, exec.Command("powershell.exe", "-NoProfile", "-Command", script))
+	return err
+}
+
+func (windowsBackend) FormatPartition(ctx context.Context, partition Partition, options FormatOptions) error {
+	script := fmt.Sprintf("$ErrorActionPreference = 'Stop'; Format-Volume -Path '%s' -FileSystem %s -NewFileSystemLabel '%s' -Confirm:$false | Out-Null",
+		windowsQuote(partition.ID), options.Filesystem, windowsQuote(options.Label))
+	_, err := runCommand(ctx, "format "+partition.ID, exec.Command("powershell.exe", "-NoProfile", "-Command", script))
+	return err
+}
+
+func (windowsBackend) Rename(ctx context.Context, partition Partition, label string) error {
+	label, err := validateLabel(partition.Filesystem, label)
+	if err != nil {
+		return err
+	}
+	script := fmt.Sprintf("$ErrorActionPreference = 'Stop'; Set-Volume -Path '%s' -NewFileSystemLabel '%s'",
+		windowsQuote(partition.ID), windowsQuote(label))
+	_, err = runCommand(ctx, "rename "+partition.ID, exec.Command("powershell.exe", "-NoProfile", "-Command", script))
+	return err
+}
+
+// windowsScanScript fails when Repair-Volume finds errors, which it only
+// reports in its result.
+const windowsScanScript = `$ErrorActionPreference = 'Stop'
+$result = Repair-Volume -Path '%s' -Scan
+"$result"
+if ($result -ne 'NoErrorsFound') { throw "Repair-Volume reported $result, the volume needs to be repaired" }`
+
+// windowsCheck checks a volume with Repair-Volume, or with chkdsk for FAT
+// and exFAT, which Repair-Volume does not support. The repair takes the
+// volume offline while it runs. chkdsk exits with 1 when it fixed errors.
+func windowsCheck(ctx context.Context, op string, partition Partition, repair bool, output func(line string)) error {
+	var cmd *exec.Cmd
+	chkdsk := strings.Contains(strings.ToUpper(partition.Filesystem), "FAT")
+	switch {
+	case chkdsk && repair:
+		// /x dismounts the volume instead of asking whether to
+		cmd = exec.Command("chkdsk", partition.ID, "/f", "/x")
+	case chkdsk:
+		cmd = exec.Command("chkdsk", partition.ID)
+	case repair:
+		script := fmt.Sprintf("$ErrorActionPreference = 'Stop'; Repair-Volume -Path '%s' -OfflineScanAndFix", windowsQuote(partition.ID))
+		cmd = exec.Command("powershell.exe", "-NoProfile", "-Command", script)
+	default:
+		cmd = exec.Command("powershell.exe", "-NoProfile", "-Command", fmt.Sprintf(windowsScanScript, windowsQuote(partition.ID)))
+	}
+	err := streamCommand(ctx, op, cmd, output)
+	if chkdsk && repair && exitCode(err) == 1 {
+		return nil
+	}
+	return err
+}
+
+func (windowsBackend) Verify(ctx context.Context, partition Partition, output func(line string)) error {
+	return windowsCheck(ctx, "verify "+partition.ID, partition, false, output)
+}
+
+func (windowsBackend) Repair(ctx context.Context, partition Partition, output func(line string)) error {
+	return windowsCheck(ctx, "repair "+partition.ID, partition, true, output)
+}
+
+// windowsTableQuery reads the layout of a disk, with the label and filesystem
+// of the volume on each partition.
+const windowsTableQuery = `$ErrorActionPreference = 'Stop'
+$disk = Get-Disk -Number %[1]s
+@{
+	Size = $disk.Size
+	PartitionStyle = [string]$disk.PartitionStyle
+	Partitions = @(Get-Partition -DiskNumber %[1]s -ErrorAction SilentlyContinue | ForEach-Object {
+		$volume = $_ | Get-Volume -ErrorAction SilentlyContinue
+		@{ PartitionNumber = $_.PartitionNumber; Offset = $_.Offset; Size = $_.Size; Type = [string]$_.Type; Label = $volume.FileSystemLabel; FileSystem = $volume.FileSystem }
+	})
+} | ConvertTo-Json -Depth 3 -Compress`
+
+type windowsTable struct {
+	Size           uint64 `json:"Size"`
+	PartitionStyle string `json:"PartitionStyle"`
+	Partitions     []struct {
+		PartitionNumber int    `json:"PartitionNumber"`
+		Offset          uint64 `json:"Offset"`
+		Size            uint64 `json:"Size"`
+		Type            string `json:"Type"`
+		Label           string `json:"Label"`
+		FileSystem      string `json:"FileSystem"`
+	} `json:"Partitions"`
+}
+
+func (windowsBackend) PartitionTable(ctx context.Context, disk Disk) (PartitionTable, error) {
+	if _, err := strconv.Atoi(disk.ID); err != nil {
+		return PartitionTable{}, userErrorf("%s has no partition table Qartion can edit", disk.Name)
+	}
+	op := "list partitions of " + disk.Device
+	output, err := windowsPowershellCommand(ctx, op, fmt.Sprintf(windowsTableQuery, disk.ID))
+	if err != nil {
+		return PartitionTable{}, err
+	}
+	var data windowsTable
+	if err := json.Unmarshal([]byte(output), &data); err != nil {
+		return PartitionTable{}, parseError(op, []byte(output), err)
+	}
+	if data.PartitionStyle != "GPT" && data.PartitionStyle != "MBR" {
+		return PartitionTable{}, userErrorf("%s has no partition table Qartion can edit", disk.Name)
+	}
+	var entries []TableEntry
+	for _, partition := range data.Partitions {
+		filesystem := partition.FileSystem
+		if filesystem == "" {
+			// the Microsoft Reserved partition and others without a volume
+			filesystem = partition.Type
 		}
-		size, _ := strconv.Atoi(values[2])
-		id := strings.TrimSpace(values[0])
-		disks.Set(id, Disk{
-			Name:       values[1],
-			ID:         id,
-			Partitions: orderedmap.New[string, Partition](),
-			Size:       uint64(size),
+		entries = append(entries, TableEntry{
+			Number:     partition.PartitionNumber,
+			Name:       partition.Label,
+			Filesystem: filesystem,
+			Offset:     partition.Offset,
+			Size:       partition.Size,
 		})
 	}
-	return disks
+	return newPartitionTable(data.PartitionStyle, data.Size, entries), nil
 }
 
-func windowsGetDiskNumbers() map[string]string {
-	d, _ := windowsPowershellCommand("Get-Partition | Select-Object DiskNumber, AccessPaths")
-	data := make(map[string]string)
-	for i, l := range strings.Split(strings.TrimSpace(d), "\n") {
-		if i < 2 {
-			continue
-		}
-		l = strings.TrimSpace(l)
-		sp := strings.Split(l, " ")
-		if len(sp) < 2 {
-			continue
-		}
-		index := len(sp) - 1
-		if len(sp) > 2 {
-			data[sp[index][:len(sp[index])-1]] = sp[0]
-		} else {
-			data[sp[index][1:len(sp[index])-1]] = sp[0]
-		}
+// CheckOperation accepts every operation, as New-Partition places a partition
+// at any offset. Resize-Partition refuses filesystems it cannot resize when
+// the plan is applied.
+func (windowsBackend) CheckOperation(table PartitionTable, op Operation) error {
+	return nil
+}
+
+// ApplyPlan runs the whole plan in one PowerShell, which stops at
+// the first operation that fails.
+func (windowsBackend) ApplyPlan(ctx context.Context, disk Disk, plan *Plan) error {
+	if _, err := strconv.Atoi(disk.ID); err != nil {
+		return userErrorf("%s has no partition table Qartion can edit", disk.Name)
 	}
-	return data
+	script := windowsPlanScript(disk.ID, plan)
+	_, err := runCommand(ctx, "edit partitions of "+disk.Device, exec.Command("powershell.exe", "-NoProfile", "-Command", script))
+	return err
 }
 
-func WindowsGetDisks() (*orderedmap.OrderedMap[string, Disk], error) {
-	pdata, _ := windowsCommand("wmic volume get DeviceID, Capacity, Label, DriveLetter")
-	ddata, _ := windowsCommand("wmic diskdrive get Model, Size, Index")
-	disks := windowsParseListDisk(ddata)
-	volus := strings.Split(strings.TrimSpace(pdata), "\n")
-	dnums := windowsGetDiskNumbers()
-	for i, vol := range volus {
-		if i == 0 {
-			continue
-		}
-		data := make(map[string]string)
-		vals := make([]string, 0)
-		for _, e := range strings.Split(vol, "  ") {
-			tr := strings.TrimSpace(e)
-			if tr != "" {
-				vals = append(vals, tr)
-			}
-		}
-		data["Capacity"] = vals[0]
-		data["DeviceID"] = vals[1]
-		switch len(vals) {
-		case 3:
-			{
-				data["Label"] = vals[2]
-			}
-		case 4:
-			{
-				data["DriveLetter"] = vals[2]
-				data["Label"] = vals[3]
-			}
-		}
-		disk, _ := disks.Get(dnums[data["DeviceID"]])
-		size, _ := strconv.Atoi(data["Capacity"])
-		mountPoint := ""
-		if data["DriveLetter"] != "" {
-			mountPoint = fmt.Sprintf("%s\\", data["DriveLetter"])
-		}
-		partition := Partition{
-			ID:         data["DeviceID"],
-			Name:       data["Label"],
-			Size:       uint64(size),
-			MountPoint: mountPoint,
-		}
-		disk.Partitions.Set(data["DeviceID"], partition)
+// windowsImageCommand runs a DiskImage cmdlet on the image at path.
+func windowsImageCommand(ctx context.Context, op string, cmdlet string, path string) error {
+	script := fmt.Sprintf("$ErrorActionPreference = 'Stop'; %s -ImagePath '%s' | Out-Null", cmdlet, windowsQuote(path))
+	_, err := runCommand(ctx, op, exec.Command("powershell.exe", "-NoProfile", "-Command", script))
+	return err
+}
+
+func (windowsBackend) AttachImage(ctx context.Context, path string) error {
+	return windowsImageCommand(ctx, "attach "+path, "Mount-DiskImage", path)
+}
+
+func (windowsBackend) DetachImage(ctx context.Context, disk Disk) error {
+	return windowsImageCommand(ctx, "detach "+disk.Image, "Dismount-DiskImage", disk.Image)
+}
+
+// windowsWatchScript prints "<EventType> <DriveName>" for every
+// Win32_VolumeChangeEvent, flushing after each line so the events are not
+// held back in PowerShell's output buffer.
+const windowsWatchScript = `Register-CimIndicationEvent -ClassName Win32_VolumeChangeEvent -SourceIdentifier Qartion | Out-Null
+while ($true) {
+	$e = Wait-Event -SourceIdentifier Qartion
+	$v = $e.SourceEventArgs.NewEvent
+	[Console]::Out.WriteLine("$($v.EventType) $($v.DriveName)")
+	[Console]::Out.Flush()
+	Remove-Event -EventIdentifier $e.EventIdentifier
+}`
+
+func (windowsBackend) Watcher() Watcher {
+	return newCommandWatcher(windowsParseVolumeEvent, "powershell.exe", "/C", windowsWatchScript)
+}
+
+// windowsVolumeInfoQuery collects the Win32_Volume properties of a volume,
+// along with those of its partition and disk that Win32_Volume lacks, such as
+// the partition type and the bus type. The BitLocker state is only readable
+// when running elevated, so it is left out otherwise.
+const windowsVolumeInfoQuery = `$volume = Get-CimInstance Win32_Volume | Where-Object DeviceID -eq '%[1]s'
+if (-not $volume) { throw 'no such volume: %[1]s' }
+$info = @{}
+$volume.CimInstanceProperties | ForEach-Object { $info[$_.Name] = $_.Value }
+$partition = Get-Partition | Where-Object { $_.AccessPaths -contains '%[1]s' } | Select-Object -First 1
+if ($partition) {
+	foreach ($name in 'DiskNumber', 'PartitionNumber', 'Offset', 'GptType', 'MbrType', 'IsBoot', 'IsSystem', 'IsHidden', 'IsReadOnly') { $info[$name] = $partition.$name }
+	$disk = Get-Disk -Number $partition.DiskNumber
+	foreach ($name in 'BusType', 'HealthStatus', 'PartitionStyle', 'LogicalSectorSize', 'PhysicalSectorSize') { $info[$name] = $disk.$name }
+}
+$encryption = Get-CimInstance -Namespace root\cimv2\Security\MicrosoftVolumeEncryption -ClassName Win32_EncryptableVolume -ErrorAction SilentlyContinue | Where-Object DeviceID -eq '%[1]s'
+if ($encryption) { $info['ProtectionStatus'] = $encryption.ProtectionStatus; $info['EncryptionMethod'] = $encryption.EncryptionMethod }
+ConvertTo-Json $info`
+
+// windowsDiskInfoQuery collects the Win32_DiskDrive properties of a disk and
+// the Get-Disk ones it lacks.
+const windowsDiskInfoQuery = `$drive = Get-CimInstance Win32_DiskDrive | Where-Object Index -eq %[1]s
+if (-not $drive) { throw 'no such disk: %[1]s' }
+$info = @{}
+$drive.CimInstanceProperties | ForEach-Object { $info[$_.Name] = $_.Value }
+$disk = Get-Disk -Number %[1]s -ErrorAction SilentlyContinue
+if ($disk) {
+	foreach ($name in 'BusType', 'HealthStatus', 'PartitionStyle', 'Guid', 'IsBoot', 'IsSystem', 'IsReadOnly', 'IsOffline', 'LogicalSectorSize', 'PhysicalSectorSize') { $info[$name] = $disk.$name }
+}
+ConvertTo-Json $info`
+
+func (windowsBackend) Info(ctx context.Context, id string) (map[string]interface{}, error) {
+	query := fmt.Sprintf(windowsVolumeInfoQuery, windowsQuote(id))
+	if _, err := strconv.Atoi(id); err == nil {
+		// disks are keyed by their Win32_DiskDrive index
+		query = fmt.Sprintf(windowsDiskInfoQuery, id)
 	}
-	return disks, nil
+	op := "get info for " + id
+	output, err := windowsPowershellCommand(ctx, op, query)
+	if err != nil {
+		return nil, err
+	}
+	data := make(map[string]interface{})
+	decoder := json.NewDecoder(strings.NewReader(output))
+	decoder.UseNumber()
+	if err := decoder.Decode(&data); err != nil {
+		return nil, parseError(op, []byte(output), err)
+	}
+	return data, nil
 }
 
-func windowsPowershellCommand(command string) (string, error) {
-	cmd := exec.Command("powershell.exe", "/C", command)
+// windowsUsedLettersQuery lists letters taken by local drives, by volumes and
+// by network shares, including persistent mappings that are disconnected
+// right now and therefore missing from Win32_LogicalDisk.
+const windowsUsedLettersQuery = `ConvertTo-Json -Compress -InputObject @(
+	@(Get-CimInstance Win32_LogicalDisk | ForEach-Object { $_.DeviceID }) +
+	@(Get-CimInstance Win32_Volume | ForEach-Object { $_.DriveLetter }) +
+	@(Get-ChildItem HKCU:\Network -ErrorAction SilentlyContinue | ForEach-Object { $_.PSChildName }) |
+	Where-Object { $_ })`
+
+func windowsUsedLetters(ctx context.Context) (map[byte]bool, error) {
+	op := "list drive letters"
+	output, err := windowsPowershellCommand(ctx, op, windowsUsedLettersQuery)
+	if err != nil {
+		return nil, err
+	}
+	var letters []string
+	if err := json.Unmarshal([]byte(output), &letters); err != nil {
+		return nil, parseError(op, []byte(output), err)
+	}
+	return windowsParseLetters(letters), nil
+}
+
+// windowsStorageQuery collects disks, volumes, partitions and attached disk
+// images in one PowerShell call. Every list is wrapped in @() so ConvertTo-Json
+// keeps it an array even when there is a single element. A VHD is attached as
+// a disk with a number, while an ISO only gets a CD-ROM volume. The BitLocker
+// state comes from Explorer, as Win32_EncryptableVolume is only readable by
+// administrators.
+const windowsStorageQuery = `$ErrorActionPreference = 'Stop'
+$shell = New-Object -ComObject Shell.Application
+@{
+	Disks = @(Get-CimInstance Win32_DiskDrive | Select-Object Index, Model, Size, DeviceID)
+	Volumes = @(Get-CimInstance Win32_Volume | Select-Object DeviceID, Capacity, Label, DriveLetter, FileSystem,
+		@{ Name = 'BitLocker'; Expression = { if ($_.DriveLetter) { $shell.NameSpace(17).ParseName($_.DriveLetter).ExtendedProperty('System.Volume.BitLockerProtection') } } })
+	Partitions = @(Get-CimInstance -Namespace root/Microsoft/Windows/Storage MSFT_Partition | Select-Object DiskNumber, PartitionNumber, AccessPaths, IsReadOnly)
+	Images = @(Get-Disk | Where-Object { $_.BusType -eq 'File Backed Virtual' } | ForEach-Object {
+		$image = $_ | Get-DiskImage
+		[pscustomobject]@{ ImagePath = $image.ImagePath; DevicePath = $image.DevicePath; Size = $image.Size; Number = $_.Number; Volume = $null }
+	}) + @(Get-Volume | Where-Object { $_.DriveType -eq 'CD-ROM' } | ForEach-Object {
+		$image = $_ | Get-DiskImage -ErrorAction SilentlyContinue
+		if ($image) { [pscustomobject]@{ ImagePath = $image.ImagePath; DevicePath = $image.DevicePath; Size = $image.Size; Number = $null; Volume = $_.Path } }
+	})
+} | ConvertTo-Json -Depth 4 -Compress`
+
+func WindowsGetDisks(ctx context.Context) (*orderedmap.OrderedMap[string, Disk], error) {
+	output, err := windowsPowershellCommand(ctx, "list disks", windowsStorageQuery)
+	if err != nil {
+		return nil, err
+	}
+	return windowsParseStorage([]byte(output))
+}
 
-	i, err := cmd.Output()
+func windowsPowershellCommand(ctx context.Context, op string, command string) (string, error) {
+	output, err := runCommand(ctx, op, exec.Command("powershell.exe", "/C", command))
 	if err != nil {
-		fmt.Println("Command execution failed:", err)
 		return "", err
 	}
-	return string(i), nil
+	return string(output), nil
 }
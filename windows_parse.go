@@ -0,0 +1,257 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+
+	orderedmap "github.com/wk8/go-ordered-map/v2"
+)
+
+// windowsRecoveryKey matches a BitLocker recovery password, eight groups of
+// six digits.
+var windowsRecoveryKey = regexp.MustCompile(`^\d{6}(-\d{6}){7}$`)
+
+// windowsParseVolumeEvent parses a line printed by windowsWatchScript. The
+// event types are 1 (configuration changed), 2 (arrival), 3 (removal) and
+// 4 (docking).
+func windowsParseVolumeEvent(line string) (DeviceEvent, bool) {
+	kind, drive, _ := strings.Cut(strings.TrimSpace(line), " ")
+	switch kind {
+	case "1", "4":
+		return DeviceEvent{Kind: DeviceChanged, Device: drive}, true
+	case "2":
+		return DeviceEvent{Kind: DeviceAdded, Device: drive}, true
+	case "3":
+		return DeviceEvent{Kind: DeviceRemoved, Device: drive}, true
+	}
+	return DeviceEvent{}, false
+}
+
+func windowsIsDriveRoot(path string) bool {
+	return len(path) >= 2 && len(path) <= 3 && path[1] == ':'
+}
+
+// windowsQuote escapes a value for use inside a single-quoted PowerShell string.
+func windowsQuote(value string) string {
+	return strings.ReplaceAll(value, "'", "''")
+}
+
+var ErrNoDriveLetter = userErrorf("all drive letters from D: to Z: are in use")
+
+func windowsParseLetters(letters []string) map[byte]bool {
+	used := make(map[byte]bool)
+	for _, letter := range letters {
+		if letter == "" {
+			continue
+		}
+		c := unicode.ToUpper(rune(letter[0]))
+		if c >= 'A' && c <= 'Z' {
+			used[byte(c)] = true
+		}
+	}
+	return used
+}
+
+// windowsAllocateLetter picks the drive letter for a volume: the preferred
+// letter when it is free, otherwise the first free letter. Only D: to Z: are
+// handed out, as A: and B: are reserved for floppy drives and C: for the
+// system drive.
+func windowsAllocateLetter(used map[byte]bool, preferred string) (string, error) {
+	if preferred != "" {
+		c := byte(unicode.ToUpper(rune(preferred[0])))
+		if c >= 'D' && c <= 'Z' && !used[c] {
+			return fmt.Sprintf("%c:\\", c), nil
+		}
+	}
+	for c := byte('D'); c <= 'Z'; c++ {
+		if !used[c] {
+			return fmt.Sprintf("%c:\\", c), nil
+		}
+	}
+	return "", ErrNoDriveLetter
+}
+
+type windowsStorage struct {
+	Disks      []windowsDiskDrive `json:"Disks"`
+	Volumes    []windowsVolume    `json:"Volumes"`
+	Partitions []windowsPartition `json:"Partitions"`
+	Images     []windowsImage     `json:"Images"`
+}
+
+type windowsDiskDrive struct {
+	Index    int    `json:"Index"`
+	Model    string `json:"Model"`
+	Size     uint64 `json:"Size"`
+	DeviceID string `json:"DeviceID"`
+}
+
+type windowsVolume struct {
+	DeviceID    string `json:"DeviceID"`
+	Capacity    uint64 `json:"Capacity"`
+	Label       string `json:"Label"`
+	DriveLetter string `json:"DriveLetter"`
+	FileSystem  string `json:"FileSystem"`
+	BitLocker   int    `json:"BitLocker"`
+}
+
+// Values of the System.Volume.BitLockerProtection property.
+const (
+	bitLockerOn         = 1
+	bitLockerEncrypting = 3
+	bitLockerDecrypting = 4
+	bitLockerSuspended  = 5
+	bitLockerLocked     = 6
+)
+
+func (v windowsVolume) encrypted() bool {
+	switch v.BitLocker {
+	case bitLockerOn, bitLockerEncrypting, bitLockerDecrypting, bitLockerSuspended, bitLockerLocked:
+		return true
+	}
+	return false
+}
+
+type windowsPartition struct {
+	DiskNumber      int      `json:"DiskNumber"`
+	PartitionNumber int      `json:"PartitionNumber"`
+	AccessPaths     []string `json:"AccessPaths"`
+	IsReadOnly      bool     `json:"IsReadOnly"`
+}
+
+type windowsImage struct {
+	ImagePath  string `json:"ImagePath"`
+	DevicePath string `json:"DevicePath"`
+	Size       uint64 `json:"Size"`
+	// Number is the disk number of an attached VHD, while Volume is the
+	// volume GUID path of an attached ISO.
+	Number *int   `json:"Number"`
+	Volume string `json:"Volume"`
+}
+
+func windowsParseStorage(output []byte) (*orderedmap.OrderedMap[string, Disk], error) {
+	var data windowsStorage
+	if err := json.Unmarshal(output, &data); err != nil {
+		return nil, parseError("list disks", output, err)
+	}
+
+	disks := orderedmap.New[string, Disk]()
+	for _, drive := range data.Disks {
+		id := strconv.Itoa(drive.Index)
+		disks.Set(id, Disk{
+			ID:         id,
+			Name:       drive.Model,
+			Size:       drive.Size,
+			Device:     drive.DeviceID,
+			Partitions: orderedmap.New[string, Partition](),
+		})
+	}
+
+	// MSFT_Partition lists the volume GUID path among its access paths, which
+	// is what ties a Win32_Volume to the disk it lives on
+	diskIDs := make(map[string]string)
+	folders := make(map[string]string)
+	readOnly := make(map[string]bool)
+	for _, partition := range data.Partitions {
+		for _, path := range partition.AccessPaths {
+			if strings.HasPrefix(path, `\\?\Volume{`) {
+				diskIDs[path] = strconv.Itoa(partition.DiskNumber)
+				readOnly[path] = partition.IsReadOnly
+				for _, folder := range partition.AccessPaths {
+					if !strings.HasPrefix(folder, `\\?\`) && !windowsIsDriveRoot(folder) {
+						folders[path] = folder
+						break
+					}
+				}
+			}
+		}
+	}
+
+	for _, image := range data.Images {
+		if image.Number != nil {
+			disk, ok := disks.Get(strconv.Itoa(*image.Number))
+			if !ok {
+				continue
+			}
+			disk.Image = image.ImagePath
+			disk.Name = imageName(image.ImagePath)
+			disks.Set(disk.ID, disk)
+		} else if image.Volume != "" {
+			disks.Set(image.DevicePath, Disk{
+				ID:         image.DevicePath,
+				Name:       imageName(image.ImagePath),
+				Size:       image.Size,
+				Device:     image.DevicePath,
+				Partitions: orderedmap.New[string, Partition](),
+				Image:      image.ImagePath,
+			})
+			diskIDs[image.Volume] = image.DevicePath
+		}
+	}
+
+	for _, volume := range data.Volumes {
+		id, ok := diskIDs[volume.DeviceID]
+		if !ok {
+			// optical drives and other volumes without a partition
+			continue
+		}
+		disk, ok := disks.Get(id)
+		if !ok {
+			continue
+		}
+		mountPoint := folders[volume.DeviceID]
+		if volume.DriveLetter != "" {
+			mountPoint = volume.DriveLetter + `\`
+		}
+		locked := volume.BitLocker == bitLockerLocked
+		if locked {
+			// a locked volume keeps its drive letter, but nothing can be
+			// read from it
+			mountPoint = ""
+		}
+		disk.Partitions.Set(volume.DeviceID, Partition{
+			ID:         volume.DeviceID,
+			Name:       volume.Label,
+			Size:       volume.Capacity,
+			Device:     volume.DeviceID,
+			UUID:       volume.DeviceID,
+			Filesystem: volume.FileSystem,
+			MountPoint: mountPoint,
+			ReadOnly:   readOnly[volume.DeviceID],
+			Encrypted:  volume.encrypted(),
+			Locked:     locked,
+		})
+	}
+	return disks, nil
+}
+
+// windowsPlanScript turns the plan into one PowerShell script. Windows
+// renumbers the partitions after the one removed, so every step finds its
+// partition by where it starts instead. Resize-Partition resizes the
+// filesystem along with the partition.
+func windowsPlanScript(diskID string, plan *Plan) string {
+	lines := []string{
+		"$ErrorActionPreference = 'Stop'",
+		"function Get-PartitionAt($offset) {",
+		"\t$partition = Get-Partition -DiskNumber " + diskID + " | Where-Object { $_.Offset -eq $offset }",
+		"\tif (-not $partition) { throw \"No partition starts at byte $offset.\" }",
+		"\t$partition",
+		"}",
+	}
+	for _, step := range plan.Steps() {
+		entry := step.Operation.Entry
+		switch step.Operation.Kind {
+		case OperationDelete:
+			lines = append(lines, fmt.Sprintf("Get-PartitionAt %d | Remove-Partition -Confirm:$false", entry.Offset))
+		case OperationCreate:
+			lines = append(lines, fmt.Sprintf("New-Partition -DiskNumber %s -Offset %d -Size %d -AssignDriveLetter | Format-Volume -FileSystem %s -NewFileSystemLabel '%s' -Confirm:$false | Out-Null",
+				diskID, entry.Offset, entry.Size, entry.Filesystem, windowsQuote(entry.Name)))
+		case OperationResize:
+			lines = append(lines, fmt.Sprintf("Get-PartitionAt %d | Resize-Partition -Size %d", entry.Offset, entry.Size))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,133 @@
+package main
+
+import "testing"
+
+func TestWindowsParseStorage(t *testing.T) {
+	tests := []struct {
+		fixture string
+		want    []string
+	}{
+		{"windows-storage-single.json", []string{
+			`0 "Samsung SSD 980 PRO 1TB" 1000202273280 `,
+			`  \\?\Volume{3f2a1c4e-0000-0000-0000-100000000000}\ "Windows" 999581069312 NTFS dev=\\?\Volume{3f2a1c4e-0000-0000-0000-100000000000}\ at=C:\ [mounted]`,
+		}},
+		{"windows-storage.json", []string{
+			`0 "NVMe WDC PC SN730 SDBQNTY-512G-1001" 512105932800 `,
+			`  \\?\Volume{a1b2c3d4-0000-0000-0000-100000000000}\ "" 511035850752 NTFS dev=\\?\Volume{a1b2c3d4-0000-0000-0000-100000000000}\ at=C:\ [mounted,encrypted]`,
+			`  \\?\Volume{a1b2c3d4-0000-0000-0000-200000000000}\ "SYSTEM" 272629760 FAT32 dev=\\?\Volume{a1b2c3d4-0000-0000-0000-200000000000}\`,
+			`  \\?\Volume{a1b2c3d4-0000-0000-0000-300000000000}\ "" 790622208 NTFS dev=\\?\Volume{a1b2c3d4-0000-0000-0000-300000000000}\`,
+			`1 "WD Elements 25A3 USB Device" 4000784417280 `,
+			`  \\?\Volume{b5c6d7e8-0000-0000-0000-100000000000}\ "Backup" 2000381014016 NTFS dev=\\?\Volume{b5c6d7e8-0000-0000-0000-100000000000}\ at=C:\Mount\Backup\ [mounted,read-only]`,
+			`  \\?\Volume{b5c6d7e8-0000-0000-0000-200000000000}\ "" 0  dev=\\?\Volume{b5c6d7e8-0000-0000-0000-200000000000}\ [locked]`,
+			`2 "scratch.vhdx" 10737418240  image=C:\Users\user\Disks\scratch.vhdx`,
+			`  \\?\Volume{c9d0e1f2-0000-0000-0000-100000000000}\ "Scratch" 10603200512 exFAT dev=\\?\Volume{c9d0e1f2-0000-0000-0000-100000000000}\ at=V:\ [mounted]`,
+			`\\.\CDROM0 "Win11_23H2_English_x64.iso" 5037133824  image=C:\Users\user\Downloads\Win11_23H2_English_x64.iso`,
+			`  \\?\Volume{d3e4f5a6-0000-0000-0000-100000000000}\ "CCCOMA_X64FRE_EN-US_DV9" 5037133824 UDF dev=\\?\Volume{d3e4f5a6-0000-0000-0000-100000000000}\ at=F:\ [mounted]`,
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.fixture, func(t *testing.T) {
+			disks, err := windowsParseStorage(readFixture(t, test.fixture))
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkLines(t, diskLines(disks), test.want)
+		})
+	}
+}
+
+func TestWindowsParseStorageInvalid(t *testing.T) {
+	// Windows PowerShell 5.1 unwraps a single result unless the query wraps it
+	// in @(), which windowsStorageQuery does
+	for _, output := range []string{"", "{", `{"Disks":{"Index":0,"Model":"Disk","Size":1}}`} {
+		if _, err := windowsParseStorage([]byte(output)); KindOf(err) != KindParseFailure {
+			t.Errorf("windowsParseStorage(%q) = %v, want a parse failure", output, err)
+		}
+	}
+}
+
+func TestWindowsAllocateLetter(t *testing.T) {
+	all := make([]string, 0, 26)
+	for c := 'A'; c <= 'Z'; c++ {
+		all = append(all, string(c)+":")
+	}
+	tests := []struct {
+		name      string
+		letters   []string
+		preferred string
+		want      string
+		err       error
+	}{
+		{"preferred free", []string{"C:", "D:"}, "m", `M:\`, nil},
+		{"preferred taken", []string{"C:", "D:", "M:"}, "M:", `E:\`, nil},
+		{"no preference", []string{"C:"}, "", `D:\`, nil},
+		{"skips A to C", nil, "", `D:\`, nil},
+		// Win32_Volume reports no letter as null, and mapped network drives
+		// are listed by their letter alone
+		{"network letters", []string{"C:", "", "d", "E", "f:"}, "", `G:\`, nil},
+		{"preferred A", []string{"C:"}, "A", `D:\`, nil},
+		{"preferred C", nil, "c:", `D:\`, nil},
+		{"preferred invalid", []string{"C:"}, "1", `D:\`, nil},
+		{"all used", all, "", "", ErrNoDriveLetter},
+		{"only A to C free", all[3:], "", "", ErrNoDriveLetter},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := windowsAllocateLetter(windowsParseLetters(test.letters), test.preferred)
+			if got != test.want || err != test.err {
+				t.Errorf("windowsAllocateLetter(%q, %q) = %q, %v, want %q, %v", test.letters, test.preferred, got, err, test.want, test.err)
+			}
+		})
+	}
+}
+
+func TestWindowsParseVolumeEvent(t *testing.T) {
+	tests := []struct {
+		line string
+		want DeviceEvent
+		ok   bool
+	}{
+		{"2 E:", DeviceEvent{DeviceAdded, "E:"}, true},
+		{"3 E:\r\n", DeviceEvent{DeviceRemoved, "E:"}, true},
+		{"1 F:", DeviceEvent{DeviceChanged, "F:"}, true},
+		{"4 ", DeviceEvent{DeviceChanged, ""}, true},
+		{"", DeviceEvent{}, false},
+		{"Id     Name            PSJobTypeName", DeviceEvent{}, false},
+	}
+	for _, test := range tests {
+		if got, ok := windowsParseVolumeEvent(test.line); got != test.want || ok != test.ok {
+			t.Errorf("windowsParseVolumeEvent(%q) = %v, %t, want %v, %t", test.line, got, ok, test.want, test.ok)
+		}
+	}
+}
+
+func TestWindowsPlanScript(t *testing.T) {
+	const gib = 1 << 30
+	plan := NewPlan(newPartitionTable("GPT", 64*gib, []TableEntry{
+		{Number: 1, Name: "EFI", Filesystem: "FAT32", Offset: 1 << 20, Size: 100 << 20},
+		{Number: 2, Name: "Old", Filesystem: "NTFS", Offset: 101 << 20, Size: 8 * gib},
+		{Number: 3, Name: "Data", Filesystem: "NTFS", Offset: 101<<20 + 8*gib, Size: 16 * gib},
+	}))
+	for _, err := range []error{
+		plan.Delete(2),
+		// partition 3 is number 2 once 2 is removed
+		plan.Resize(3, 32*gib),
+		plan.Create(101<<20, 4*gib, FilesystemExFAT, "Bob's"),
+	} {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := `$ErrorActionPreference = 'Stop'
+function Get-PartitionAt($offset) {
+	$partition = Get-Partition -DiskNumber 1 | Where-Object { $_.Offset -eq $offset }
+	if (-not $partition) { throw "No partition starts at byte $offset." }
+	$partition
+}
+Get-PartitionAt 105906176 | Remove-Partition -Confirm:$false
+Get-PartitionAt 8695840768 | Resize-Partition -Size 34359738368
+New-Partition -DiskNumber 1 -Offset 105906176 -Size 4294967296 -AssignDriveLetter | Format-Volume -FileSystem exFAT -NewFileSystemLabel 'Bob''s' -Confirm:$false | Out-Null`
+	if got := windowsPlanScript("1", plan); got != want {
+		t.Errorf("windowsPlanScript = \n%s\nwant\n%s", got, want)
+	}
+}